@@ -0,0 +1,96 @@
+package harn
+
+import (
+	"fmt"
+	"strings"
+)
+
+// inlineCase is a test defined inline in a -cases manifest rather than in files of its own
+type inlineCase struct {
+	name     string
+	input    string
+	expected string
+	// hasExpected is false for cases without an expect entry, which need -expected-cmd
+	hasExpected bool
+}
+
+// readCases reads a -cases manifest of small tests defined inline:
+//
+//	case small
+//	input 1 2
+//	expect 3
+//
+//	case two lines
+//	input <<EOF
+//	2
+//	1 2
+//	EOF
+//	expect <<EOF
+//	3
+//	EOF
+//
+// A value on the same line as its keyword is a single line. "<<DELIM" starts a
+// heredoc that runs until a line holding only DELIM. Blank lines and lines starting
+// with # are ignored outside heredocs. Each case is named "manifest:name".
+func readCases(filename string) ([]inlineCase, error) {
+	content, err := readFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(content, "\n")
+	var cases []inlineCase
+	seen := make(map[string]bool)
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keyword, value, _ := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+		lineNumber := i + 1
+		if keyword == "case" {
+			if value == "" {
+				return nil, fmt.Errorf("%s:%d: case without a name", filename, lineNumber)
+			}
+			name := filename + ":" + value
+			if seen[name] {
+				return nil, fmt.Errorf("%s:%d: duplicate case %q", filename, lineNumber, value)
+			}
+			seen[name] = true
+			cases = append(cases, inlineCase{name: name})
+			continue
+		}
+		if keyword != "input" && keyword != "expect" {
+			return nil, fmt.Errorf("%s:%d: unknown entry %q (expected case, input or expect)", filename, lineNumber, keyword)
+		}
+		if len(cases) == 0 {
+			return nil, fmt.Errorf("%s:%d: %s before the first case", filename, lineNumber, keyword)
+		}
+		text := value + "\n"
+		if strings.HasPrefix(value, "<<") {
+			delim := strings.TrimSpace(value[2:])
+			if delim == "" {
+				return nil, fmt.Errorf("%s:%d: heredoc without a delimiter", filename, lineNumber)
+			}
+			end := i + 1
+			for end < len(lines) && strings.TrimSpace(lines[end]) != delim {
+				end++
+			}
+			if end == len(lines) {
+				return nil, fmt.Errorf("%s:%d: heredoc is never closed by %s", filename, lineNumber, delim)
+			}
+			text = strings.Join(lines[i+1:end], "\n")
+			if end > i+1 {
+				text += "\n"
+			}
+			i = end
+		}
+		c := &cases[len(cases)-1]
+		if keyword == "input" {
+			c.input = text
+		} else {
+			c.expected, c.hasExpected = text, true
+		}
+	}
+	return cases, nil
+}
@@ -0,0 +1,98 @@
+package harn
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadCases(t *testing.T) {
+	manifest := filepath.Join(t.TempDir(), "smoke.cases")
+	content := "# smoke tests\ncase small\ninput 1 2\nexpect 3\n\ncase two lines\ninput <<END\n-5\n\n3\nEND\nexpect <<END\nEND\n"
+	if err := os.WriteFile(manifest, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases, err := readCases(manifest)
+	if err != nil {
+		t.Fatalf("readCases() failed: %v", err)
+	}
+	want := []inlineCase{
+		{name: manifest + ":small", input: "1 2\n", expected: "3\n", hasExpected: true},
+		{name: manifest + ":two lines", input: "-5\n\n3\n", expected: "", hasExpected: true},
+	}
+	if len(cases) != len(want) {
+		t.Fatalf("readCases() = %+v, want %+v", cases, want)
+	}
+	for i := range want {
+		if cases[i] != want[i] {
+			t.Errorf("case %d = %+v, want %+v", i, cases[i], want[i])
+		}
+	}
+
+	for name, content := range map[string]string{
+		"before case": "input 1 2\n",
+		"unclosed":    "case a\ninput <<END\n1 2\n",
+		"duplicate":   "case a\ninput 1\ncase a\ninput 2\n",
+		"unknown":     "case a\noutput 3\n",
+	} {
+		if err := os.WriteFile(manifest, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readCases(manifest); err == nil {
+			t.Errorf("%s: readCases() accepted an invalid manifest", name)
+		} else if !strings.HasPrefix(err.Error(), manifest+":") {
+			t.Errorf("%s: error %q doesn't point at the manifest line", name, err)
+		}
+	}
+}
+
+func TestRunCases(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"file": {"1 2", "3"},
+	})
+	manifest := filepath.Join(dir, "smoke.cases")
+	if err := os.WriteFile(manifest, []byte("case ac\ninput 2 2\nexpect 4\ncase wa\ninput <<END\n2\n3\nEND\nexpect 6\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results, _ := runFake(t, dir, Config{CasesFile: manifest})
+	verdicts := make(map[string]string)
+	for _, result := range results.Tests {
+		verdicts[result.Name] = result.Verdict
+	}
+	want := map[string]string{filepath.Join(dir, "file.in"): VerdictAC, manifest + ":ac": VerdictAC, manifest + ":wa": VerdictWA}
+	if len(verdicts) != len(want) {
+		t.Fatalf("got verdicts %v, want %v", verdicts, want)
+	}
+	for name, verdict := range want {
+		if verdicts[name] != verdict {
+			t.Errorf("%s: got verdict %s, want %s", name, verdicts[name], verdict)
+		}
+	}
+}
+
+func TestRunStdin(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{Expect: "3"}, VerdictAC},
+		{Config{Expect: "4"}, VerdictWA},
+		{Config{ExpectedCmd: "echo 3"}, VerdictAC},
+	} {
+		cfg := tc.cfg
+		cfg.Program, cfg.Pattern = "fake", "-"
+		runner := &Runner{Out: &bytes.Buffer{}, Stdin: strings.NewReader("1 2\n"), executor: fakeExecutor{}}
+		results, err := runner.Run(cfg)
+		if err != nil {
+			t.Fatalf("Run(%+v) failed: %v", tc.cfg, err)
+		}
+		if len(results.Tests) != 1 || results.Tests[0].Verdict != tc.want {
+			t.Errorf("Run(%+v): got %+v, want a single %s", tc.cfg, results.Tests, tc.want)
+		}
+	}
+	if _, err := (&Runner{Out: &bytes.Buffer{}, Stdin: strings.NewReader(""), executor: fakeExecutor{}}).Run(Config{Program: "fake", Pattern: "-"}); err == nil {
+		t.Error("Run() accepted a \"-\" pattern without an expected output")
+	}
+}
@@ -0,0 +1,790 @@
+package harn
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Trim modes accepted by Config.Trim
+const (
+	TrimFull       = "full"
+	TrimBlankLines = "blank-lines"
+	TrimNone       = "none"
+	// TrimCollapseSpaces keeps line breaks but treats any run of spaces and tabs like one space
+	TrimCollapseSpaces = "collapse-spaces"
+)
+
+// Units checked by Config.AssertSorted
+const (
+	SortedLines  = "lines"
+	SortedTokens = "tokens"
+)
+
+var validSortedUnits = map[string]bool{"": true, SortedLines: true, SortedTokens: true}
+
+var validTrimModes = map[string]bool{TrimFull: true, TrimBlankLines: true, TrimNone: true, TrimCollapseSpaces: true}
+
+// normalizeOutput prepares an output for comparison according to the trim mode.
+// Line endings are always normalized the same way readFile normalizes expected files.
+func normalizeOutput(output, trimMode string) string {
+	output = normalizeEOL(output)
+	switch trimMode {
+	case TrimNone:
+		return output
+	case TrimBlankLines:
+		return trimBlankLineEdges(output)
+	case TrimCollapseSpaces:
+		return collapseSpaces(trimBlankLineEdges(output))
+	default:
+		return strings.TrimSpace(output)
+	}
+}
+
+// normalizeEOL converts CRLF line endings to LF and drops a single final line ending
+func normalizeEOL(output string) string {
+	output = strings.ReplaceAll(output, "\r\n", "\n")
+	output = strings.TrimSuffix(output, "\n")
+	return strings.TrimSuffix(output, "\r")
+}
+
+// stripComments removes the lines that start with prefix, ignoring indentation
+func stripComments(output, prefix string) string {
+	lines := strings.SplitAfter(output, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.TrimLeft(line, " \t"), prefix) {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "")
+}
+
+// ansiEscape matches ANSI escape sequences: CSI sequences such as colors and cursor
+// movements, OSC sequences such as terminal titles and hyperlinks, and two-byte escapes
+var ansiEscape = regexp.MustCompile(`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[@-Z\\-_])`)
+
+// stripANSI removes the ANSI escape sequences from an output, for -strip-ansi
+func stripANSI(output string) string {
+	if !strings.Contains(output, "\x1b") {
+		return output
+	}
+	return ansiEscape.ReplaceAllString(output, "")
+}
+
+// selectColumns keeps the given columns (1-based) of every line, in the given order.
+// Columns are split like tokens, by delims or by whitespace, and joined back with
+// the first delimiter or a space. Blank lines stay blank.
+func selectColumns(output string, columns []int, delims string) (string, error) {
+	separator := " "
+	if delims != "" {
+		separator = delims[:1]
+	}
+	lines := strings.Split(normalizeEOL(output), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+			continue
+		}
+		var fields []string
+		if delims == "" {
+			fields = strings.Fields(line)
+		} else {
+			fields = strings.FieldsFunc(line, func(r rune) bool { return strings.ContainsRune(delims, r) })
+		}
+		selected := make([]string, len(columns))
+		for j, column := range columns {
+			if column > len(fields) {
+				return "", fmt.Errorf("line %d has %d columns, column %d is missing", i+1, len(fields), column)
+			}
+			selected[j] = strings.TrimSpace(fields[column-1])
+		}
+		lines[i] = strings.Join(selected, separator)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// trimBlankLineEdges removes leading and trailing lines that contain only whitespace,
+// leaving the remaining lines untouched
+func trimBlankLineEdges(output string) string {
+	lines := strings.Split(output, "\n")
+	start, end := 0, len(lines)
+	for start < end && strings.TrimSpace(lines[start]) == "" {
+		start++
+	}
+	for end > start && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return strings.Join(lines[start:end], "\n")
+}
+
+// collapseSpaces turns every run of whitespace within a line into a single space and
+// drops whitespace at the start and end of lines, leaving the line breaks alone
+func collapseSpaces(output string) string {
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// compareOptions configures how an actual output is compared with an expected output
+type compareOptions struct {
+	trim string
+	// tokens compares whitespace-separated tokens, numeric tokens by value
+	tokens bool
+	// rows additionally requires tokens to stay on the same lines, comparing line by line
+	rows bool
+	// leadingZeros drops leading zeros and a leading + from numeric tokens before comparing them (007 = +7 = 7)
+	leadingZeros bool
+	// intFloat compares tokens literally, except that whole numbers match in integer and decimal spellings (5 = 5.0)
+	intFloat bool
+	// rounded compares numeric tokens after rounding them to decimals decimal places, instead of within eps
+	rounded  bool
+	decimals int
+	// eps is the absolute or relative error accepted between numeric tokens
+	eps float64
+	// delims lists the characters separating tokens, in addition to line breaks. Empty means whitespace.
+	delims string
+	// binary compares the raw bytes, without any trimming or line ending normalization
+	binary bool
+	// contains accepts outputs that contain the expected output anywhere, after trimming both
+	contains bool
+	// multiset accepts outputs with the same tokens as the expected output, each the same number of times, in any order
+	multiset bool
+	// columns keeps only these columns (1-based) of each line of both outputs, split by delims, unless empty
+	columns []int
+	// commentPrefix drops the lines starting with it from both outputs before comparing, unless empty
+	commentPrefix string
+	// whitespace accepts outputs that only differ from the expected output in whitespace
+	whitespace bool
+	// fuzzy accepts outputs within maxEdits character insertions, deletions or substitutions of the
+	// expected output, after trimming both
+	fuzzy    bool
+	maxEdits int
+	// extraLines accepts outputs whose lines beyond the expected output's line count make
+	// it fail, as if they weren't there
+	extraLines bool
+}
+
+// judgement is the outcome of comparing an output with its acceptable expected outputs
+type judgement struct {
+	// verdict is VerdictAC or VerdictWA
+	verdict string
+	// matched is the index of the expected output that matched, or -1
+	matched int
+	// whitespaceOnly is set when the output only matched by ignoring whitespace
+	whitespaceOnly bool
+	// mismatch describes the first difference of a WA when there is a single expected output
+	mismatch string
+	// extraLines counts the trailing output lines ignored to match, under -allow-extra-lines
+	extraLines int
+}
+
+// judge decides whether an output is accepted by any of the expected outputs.
+// Exact matches (under the comparison mode) are preferred over whitespace-only ones.
+func judge(expected []string, actual string, opts compareOptions) judgement {
+	if opts.commentPrefix != "" {
+		actual = stripComments(actual, opts.commentPrefix)
+		uncommented := make([]string, len(expected))
+		for i, expectedOutput := range expected {
+			uncommented[i] = stripComments(expectedOutput, opts.commentPrefix)
+		}
+		expected = uncommented
+	}
+	if len(opts.columns) > 0 {
+		var err error
+		if actual, err = selectColumns(actual, opts.columns, opts.delims); err != nil {
+			return judgement{verdict: VerdictWA, matched: -1, mismatch: "output " + err.Error()}
+		}
+		selected := make([]string, len(expected))
+		for i, expectedOutput := range expected {
+			if selected[i], err = selectColumns(expectedOutput, opts.columns, opts.delims); err != nil {
+				return judgement{verdict: VerdictWA, matched: -1, mismatch: "expected output " + err.Error()}
+			}
+		}
+		expected = selected
+	}
+	for i, expectedOutput := range expected {
+		if ok, _ := compareOutputs(expectedOutput, actual, opts); ok {
+			return judgement{verdict: VerdictAC, matched: i}
+		}
+	}
+	if opts.extraLines {
+		for i, expectedOutput := range expected {
+			if prefix, dropped := dropExtraLines(expectedOutput, actual); dropped > 0 {
+				if ok, _ := compareOutputs(expectedOutput, prefix, opts); ok {
+					return judgement{verdict: VerdictAC, matched: i, extraLines: dropped}
+				}
+			}
+		}
+	}
+	if opts.whitespace {
+		for i, expectedOutput := range expected {
+			if equalIgnoringWhitespace(actual, expectedOutput) {
+				return judgement{verdict: VerdictAC, matched: i, whitespaceOnly: true}
+			}
+		}
+	}
+
+	j := judgement{verdict: VerdictWA, matched: -1}
+	if len(expected) == 1 {
+		_, j.mismatch = compareOutputs(expected[0], actual, opts)
+	}
+	return j
+}
+
+// compareOutputs reports whether actual matches expected, and if not, a short
+// description of the first difference when one can be pinpointed
+func compareOutputs(expected, actual string, opts compareOptions) (bool, string) {
+	if opts.binary {
+		return compareBinary(expected, actual)
+	}
+	if opts.rows {
+		return compareRows(expected, actual, opts)
+	}
+	if opts.contains {
+		return compareContains(normalizeOutput(expected, opts.trim), normalizeOutput(actual, opts.trim))
+	}
+	if opts.multiset {
+		return compareMultiset(tokenize(expected, opts.delims), tokenize(actual, opts.delims))
+	}
+	if opts.tokens {
+		return compareTokens(tokenize(expected, opts.delims), tokenize(actual, opts.delims), opts)
+	}
+	expected, actual = normalizeOutput(expected, opts.trim), normalizeOutput(actual, opts.trim)
+	if expected == actual {
+		return true, ""
+	}
+	if opts.fuzzy {
+		return compareEdits(expected, actual, opts.maxEdits)
+	}
+	if strings.TrimRight(expected, "\r\n") == strings.TrimRight(actual, "\r\n") {
+		return false, "outputs differ only by trailing newline"
+	}
+	return false, ""
+}
+
+// dropExtraLines cuts actual down to as many lines as expected has, ignoring the
+// expected output's trailing line breaks. It reports how many lines it dropped, not
+// counting blank lines at the very end.
+func dropExtraLines(expected, actual string) (string, int) {
+	want := 0
+	if trimmed := strings.TrimRight(expected, "\r\n"); trimmed != "" {
+		want = strings.Count(trimmed, "\n") + 1
+	}
+	trimmed := strings.TrimRight(actual, "\r\n")
+	lines := strings.SplitAfter(trimmed, "\n")
+	if trimmed == "" || len(lines) <= want {
+		return actual, 0
+	}
+	return strings.Join(lines[:want], ""), len(lines) - want
+}
+
+// compareContains reports whether expected appears in actual, describing otherwise
+// where actual comes closest to it
+func compareContains(expected, actual string) (bool, string) {
+	if strings.Contains(actual, expected) {
+		return true, ""
+	}
+	offset, length := closestRegion(expected, actual)
+	if length == 0 {
+		return false, "expected output not found"
+	}
+	line := strings.Count(actual[:offset], "\n") + 1
+	region := actual[offset:]
+	if len(region) > len(expected) {
+		region = region[:len(expected)]
+	}
+	if len(region) > 40 {
+		region = region[:40] + "..."
+	}
+	return false, fmt.Sprintf("expected output not found, closest at line %d: %q", line, region)
+}
+
+// closestRegionPrefix bounds how much of the expected output closestRegion matches, so
+// that repetitive outputs can't make the search quadratic in their full length
+const closestRegionPrefix = 256
+
+// closestRegion finds the offset in actual where the longest prefix of expected
+// appears, returning the offset and the length of that prefix
+func closestRegion(expected, actual string) (int, int) {
+	if len(expected) > closestRegionPrefix {
+		expected = expected[:closestRegionPrefix]
+	}
+	bestOffset, bestLength := 0, 0
+	for offset := 0; offset < len(actual); offset++ {
+		length := 0
+		for length < len(expected) && offset+length < len(actual) && actual[offset+length] == expected[length] {
+			length++
+		}
+		if length > bestLength {
+			bestOffset, bestLength = offset, length
+		}
+	}
+	return bestOffset, bestLength
+}
+
+// editDistanceWork bounds the cells compareEdits fills in while working out the edit
+// distance to report on a failure, beyond which it only reports a lower bound
+const editDistanceWork = 1 << 26
+
+// compareEdits reports whether actual is within maxEdits edits of expected, and
+// otherwise how far apart they are
+func compareEdits(expected, actual string, maxEdits int) (bool, string) {
+	a, b := []rune(expected), []rune(actual)
+	if _, ok := boundedEditDistance(a, b, maxEdits); ok {
+		return true, ""
+	}
+	// The distance is worked out with a widening bound, so close outputs stay cheap
+	bound := maxEdits
+	for {
+		if bound = 2*bound + 1; (2*bound+1)*(len(a)+1) > editDistanceWork {
+			return false, fmt.Sprintf("edit distance is more than %d, over the -max-edits %d", bound/2, maxEdits)
+		}
+		if distance, ok := boundedEditDistance(a, b, bound); ok {
+			return false, fmt.Sprintf("edit distance %d is over the -max-edits %d", distance, maxEdits)
+		}
+	}
+}
+
+// boundedEditDistance computes the Levenshtein distance between a and b if it is at most
+// bound, reporting whether it is. Only the diagonal band of cells within bound of each other
+// is filled in, taking O(len(a) * bound) time rather than O(len(a) * len(b)).
+func boundedEditDistance(a, b []rune, bound int) (int, bool) {
+	if len(a)-len(b) > bound || len(b)-len(a) > bound {
+		return 0, false
+	}
+	// Cells outside the band hold bound+1, which is as good as infinity here
+	beyond := bound + 1
+	previous, current := make([]int, len(b)+1), make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+		if j > bound {
+			previous[j] = beyond
+		}
+	}
+	for i := 1; i <= len(a); i++ {
+		low, high := i-bound, i+bound
+		if low < 1 {
+			low = 1
+		}
+		if high > len(b) {
+			high = len(b)
+		}
+		current[0] = i
+		if i > bound {
+			current[0] = beyond
+		}
+		if low > 1 {
+			current[low-1] = beyond
+		}
+		rowMin := current[0]
+		for j := low; j <= high; j++ {
+			cost := previous[j-1]
+			if a[i-1] != b[j-1] {
+				cost++
+			}
+			if previous[j]+1 < cost {
+				cost = previous[j] + 1
+			}
+			if current[j-1]+1 < cost {
+				cost = current[j-1] + 1
+			}
+			if cost > beyond {
+				cost = beyond
+			}
+			current[j] = cost
+			if cost < rowMin {
+				rowMin = cost
+			}
+		}
+		if high < len(b) {
+			current[high+1] = beyond
+		}
+		if rowMin > bound {
+			return 0, false
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)], previous[len(b)] <= bound
+}
+
+// tokenize splits an output into tokens. Without delimiters, tokens are separated by
+// whitespace; otherwise by any delimiter character or line break, with surrounding
+// whitespace trimmed and empty tokens dropped.
+func tokenize(output, delims string) []string {
+	if delims == "" {
+		return strings.Fields(output)
+	}
+	fields := strings.FieldsFunc(output, func(r rune) bool {
+		return r == '\n' || r == '\r' || strings.ContainsRune(delims, r)
+	})
+	tokens := fields[:0]
+	for _, field := range fields {
+		if field = strings.TrimSpace(field); field != "" {
+			tokens = append(tokens, field)
+		}
+	}
+	return tokens
+}
+
+// compareTokens compares outputs token by token. Tokens that both parse as
+// numbers are compared by value, within eps absolute or relative error, or
+// once rounded to the same number of decimals.
+func compareTokens(expectedTokens, actualTokens []string, opts compareOptions) (bool, string) {
+	for i := 0; i < len(expectedTokens) && i < len(actualTokens); i++ {
+		// Tokens are compared as normalized, but mismatches show them as written
+		expectedToken, actualToken := expectedTokens[i], actualTokens[i]
+		if opts.leadingZeros {
+			expectedToken, actualToken = stripLeadingZeros(expectedToken), stripLeadingZeros(actualToken)
+		}
+		if opts.rounded {
+			expectedRounded, expectedOk := roundToken(expectedToken, opts.decimals)
+			actualRounded, actualOk := roundToken(actualToken, opts.decimals)
+			if expectedOk && actualOk {
+				if expectedRounded != actualRounded {
+					return false, fmt.Sprintf("token %d: expected %q, got %q (%s and %s once rounded)",
+						i+1, expectedTokens[i], actualTokens[i], expectedRounded, actualRounded)
+				}
+				continue
+			}
+		}
+		if opts.intFloat {
+			if !wholeNumbersEqual(expectedToken, actualToken) {
+				return false, fmt.Sprintf("token %d: expected %q, got %q", i+1, expectedTokens[i], actualTokens[i])
+			}
+			continue
+		}
+		if !tokensEqual(expectedToken, actualToken, opts.eps) {
+			return false, fmt.Sprintf("token %d: expected %q, got %q", i+1, expectedTokens[i], actualTokens[i])
+		}
+	}
+	if len(expectedTokens) != len(actualTokens) {
+		return false, fmt.Sprintf("expected %d tokens, got %d", len(expectedTokens), len(actualTokens))
+	}
+	return true, ""
+}
+
+// maxMultisetMismatches is how many tokens with differing counts compareMultiset describes
+const maxMultisetMismatches = 3
+
+// compareMultiset compares outputs as multisets of tokens: every token must appear
+// the same number of times in both, in any order. Tokens are compared literally.
+func compareMultiset(expectedTokens, actualTokens []string) (bool, string) {
+	counts := make(map[string]int)
+	// order keeps the tokens by first appearance, expected ones first, so mismatches are reported stably
+	var order []string
+	for _, token := range expectedTokens {
+		if _, seen := counts[token]; !seen {
+			order = append(order, token)
+		}
+		counts[token]++
+	}
+	actualCounts := make(map[string]int)
+	for _, token := range actualTokens {
+		if _, seen := counts[token]; !seen && actualCounts[token] == 0 {
+			order = append(order, token)
+		}
+		actualCounts[token]++
+	}
+
+	var mismatches []string
+	differing := 0
+	for _, token := range order {
+		if counts[token] == actualCounts[token] {
+			continue
+		}
+		differing++
+		if len(mismatches) < maxMultisetMismatches {
+			mismatches = append(mismatches, fmt.Sprintf("%q expected %s, got %d", token, times(counts[token]), actualCounts[token]))
+		}
+	}
+	if differing == 0 {
+		return true, ""
+	}
+	if differing > len(mismatches) {
+		mismatches = append(mismatches, fmt.Sprintf("%d more tokens differ", differing-len(mismatches)))
+	}
+	return false, strings.Join(mismatches, "; ")
+}
+
+// times spells out a token count, as in "expected 3 times"
+func times(n int) string {
+	if n == 1 {
+		return "once"
+	}
+	return fmt.Sprintf("%d times", n)
+}
+
+// compareRows compares outputs line by line, requiring the same number of lines,
+// and the tokens of each line with compareTokens
+func compareRows(expected, actual string, opts compareOptions) (bool, string) {
+	expectedRows := strings.Split(normalizeOutput(expected, opts.trim), "\n")
+	actualRows := strings.Split(normalizeOutput(actual, opts.trim), "\n")
+	if len(expectedRows) != len(actualRows) {
+		return false, fmt.Sprintf("expected %d rows, got %d", len(expectedRows), len(actualRows))
+	}
+	for i := range expectedRows {
+		if ok, mismatch := compareTokens(tokenize(expectedRows[i], opts.delims), tokenize(actualRows[i], opts.delims), opts); !ok {
+			if strings.HasPrefix(mismatch, "token") {
+				return false, fmt.Sprintf("row %d %s", i+1, mismatch)
+			}
+			return false, fmt.Sprintf("row %d: %s", i+1, mismatch)
+		}
+	}
+	return true, ""
+}
+
+// stripLeadingZeros drops the leading + and the leading zeros of the integer part of a
+// decimal number, keeping one digit before any decimal point: +007 becomes 7 and -00.50
+// becomes -0.50. Other tokens are returned as they are.
+func stripLeadingZeros(token string) string {
+	sign, number := "", token
+	if strings.HasPrefix(number, "+") || strings.HasPrefix(number, "-") {
+		sign, number = strings.TrimPrefix(number[:1], "+"), number[1:]
+	}
+	integer, fraction := number, ""
+	if i := strings.IndexByte(number, '.'); i >= 0 {
+		integer, fraction = number[:i], number[i:]
+	}
+	if integer == "" || strings.Trim(integer, "0123456789") != "" || strings.Trim(strings.TrimPrefix(fraction, "."), "0123456789") != "" {
+		return token
+	}
+	if integer = strings.TrimLeft(integer, "0"); integer == "" {
+		integer = "0"
+	}
+	return sign + integer + fraction
+}
+
+// roundToken rounds a numeric token to the given number of decimals, reporting
+// whether the token is a number at all
+func roundToken(token string, decimals int) (string, bool) {
+	value, err := strconv.ParseFloat(token, 64)
+	if err != nil {
+		return "", false
+	}
+	rounded := strconv.FormatFloat(value, 'f', decimals, 64)
+	// -0.00 and 0.00 are the same answer
+	if strings.Trim(rounded, "-0.") == "" {
+		rounded = strings.TrimPrefix(rounded, "-")
+	}
+	return rounded, true
+}
+
+// wholeNumbersEqual compares two tokens literally, except that whole numbers are
+// equal whatever zeros follow their decimal point, so 5, 5. and 5.00 are the same
+func wholeNumbersEqual(expected, actual string) bool {
+	if expected == actual {
+		return true
+	}
+	a, okA := wholeNumber(expected)
+	b, okB := wholeNumber(actual)
+	return okA && okB && a == b
+}
+
+// wholeNumber returns the integer spelling of a whole number such as "-5" or "5.00",
+// reporting whether the token is one. The digits are kept as they are, so there's
+// no loss of precision on large numbers.
+func wholeNumber(token string) (string, bool) {
+	digits := strings.TrimPrefix(token, "-")
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		if strings.Trim(digits[i+1:], "0") != "" {
+			return "", false
+		}
+		digits = digits[:i]
+	}
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return "", false
+	}
+	if strings.Trim(digits, "0") == "" {
+		// -0 and 0.0 are both zero
+		return "0", true
+	}
+	if strings.HasPrefix(token, "-") {
+		return "-" + digits, true
+	}
+	return digits, true
+}
+
+// tokensEqual compares two tokens, numerically when both are numbers
+func tokensEqual(expected, actual string, eps float64) bool {
+	if expected == actual {
+		return true
+	}
+	a, errA := strconv.ParseFloat(expected, 64)
+	b, errB := strconv.ParseFloat(actual, 64)
+	if errA != nil || errB != nil {
+		return false
+	}
+	if a == b {
+		return true
+	}
+	diff := math.Abs(a - b)
+	return diff <= eps || diff <= eps*math.Abs(a)
+}
+
+// checkSorted reports whether the lines or tokens of an output are in nondecreasing
+// order, describing otherwise the first one out of order. Two numbers are compared
+// by value, anything else as text. Lines are trimmed and blank lines skipped, tokens
+// are split like the token comparisons split them, by delims when given.
+func checkSorted(output, unit, delims string) (bool, string) {
+	var items []string
+	if unit == SortedTokens {
+		items = tokenize(output, delims)
+	} else {
+		for _, line := range strings.Split(output, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				items = append(items, line)
+			}
+		}
+	}
+	for i := 1; i < len(items); i++ {
+		if lessItem(items[i], items[i-1]) {
+			return false, fmt.Sprintf("%s %d (%q) comes after %q", strings.TrimSuffix(unit, "s"), i+1, items[i], items[i-1])
+		}
+	}
+	return true, ""
+}
+
+// lessItem orders two lines or tokens for checkSorted
+func lessItem(a, b string) bool {
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		return x < y
+	}
+	return a < b
+}
+
+// equalIgnoringWhitespace reports whether two outputs contain the same words,
+// regardless of spacing, trailing whitespace or blank lines
+func equalIgnoringWhitespace(a, b string) bool {
+	aFields, bFields := strings.Fields(a), strings.Fields(b)
+	if len(aFields) != len(bFields) {
+		return false
+	}
+	for i := range aFields {
+		if aFields[i] != bFields[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// firstDifference finds the first line (1-based) where two outputs differ, along
+// with that line on each side. It returns 0 when the outputs have the same lines.
+func firstDifference(expected, actual string) (int, string, string) {
+	expectedLines := strings.Split(normalizeEOL(expected), "\n")
+	actualLines := strings.Split(normalizeEOL(actual), "\n")
+	for i := 0; i < len(expectedLines) || i < len(actualLines); i++ {
+		var expectedLine, actualLine string
+		if i < len(expectedLines) {
+			expectedLine = expectedLines[i]
+		}
+		if i < len(actualLines) {
+			actualLine = actualLines[i]
+		}
+		if i >= len(expectedLines) || i >= len(actualLines) || expectedLine != actualLine {
+			return i + 1, expectedLine, actualLine
+		}
+	}
+	return 0, "", ""
+}
+
+// diffSnippet describes the first differing line of a failing test in a few short lines
+func diffSnippet(expectedFile, expected, actual string) string {
+	line, expectedLine, actualLine := firstDifference(expected, actual)
+	if line == 0 {
+		return "Outputs differ only in trailing whitespace"
+	}
+	const maxLen = 80
+	if len(expectedLine) > maxLen {
+		expectedLine = expectedLine[:maxLen] + "..."
+	}
+	if len(actualLine) > maxLen {
+		actualLine = actualLine[:maxLen] + "..."
+	}
+	return fmt.Sprintf("First difference at %s:%d\n- expected: %s\n+ actual:   %s", expectedFile, line, expectedLine, actualLine)
+}
+
+// compareBinary compares outputs byte for byte, describing the first differing offset
+func compareBinary(expected, actual string) (bool, string) {
+	if expected == actual {
+		return true, ""
+	}
+	offset := firstDifferingByte(expected, actual)
+	switch offset {
+	case len(expected):
+		return false, fmt.Sprintf("expected %d bytes, got %d bytes with the same prefix", len(expected), len(actual))
+	case len(actual):
+		return false, fmt.Sprintf("expected %d bytes, output ended after %d", len(expected), len(actual))
+	}
+	return false, fmt.Sprintf("first difference at byte offset %d, 0x%x", offset, offset)
+}
+
+// firstDifferingByte returns the offset of the first byte that differs, or the
+// length of the shorter input when one is a prefix of the other
+func firstDifferingByte(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// hexDumpLines is how many 16-byte lines of context hexDump shows
+const hexDumpLines = 4
+
+// hexDump formats the bytes around offset like hexdump -C, starting one line
+// before the line that contains offset
+func hexDump(data string, offset int) string {
+	start := offset - offset%16 - 16
+	if start < 0 {
+		start = 0
+	}
+	end := start + hexDumpLines*16
+	if end > len(data) {
+		end = len(data)
+	}
+	if start >= end {
+		return "(no data)"
+	}
+
+	var dump strings.Builder
+	for line := start; line < end; line += 16 {
+		fmt.Fprintf(&dump, "%08x  ", line)
+		for i := line; i < line+16; i++ {
+			if i < end {
+				fmt.Fprintf(&dump, "%02x ", data[i])
+			} else {
+				dump.WriteString("   ")
+			}
+			if i == line+7 {
+				dump.WriteString(" ")
+			}
+		}
+		dump.WriteString(" |")
+		for i := line; i < line+16 && i < end; i++ {
+			if data[i] >= 0x20 && data[i] < 0x7f {
+				dump.WriteByte(data[i])
+			} else {
+				dump.WriteByte('.')
+			}
+		}
+		dump.WriteString("|")
+		if line+16 < end {
+			dump.WriteString("\n")
+		}
+	}
+	return dump.String()
+}
+
+// binarySnippet shows both outputs around their first differing byte
+func binarySnippet(expectedFile, expected, actual string) string {
+	offset := firstDifferingByte(expected, actual)
+	return fmt.Sprintf("First difference in %s at byte offset %d (0x%x)\n- expected:\n%s\n+ actual:\n%s",
+		expectedFile, offset, offset, hexDump(expected, offset), hexDump(actual, offset))
+}
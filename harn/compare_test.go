@@ -0,0 +1,324 @@
+package harn
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+func TestJudge(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected []string
+		actual   string
+		opts     compareOptions
+		verdict  string
+		matched  int
+		mismatch string
+	}{
+		{"exact", []string{"3"}, "3", compareOptions{trim: TrimFull}, VerdictAC, 0, ""},
+		{"wrong answer", []string{"3"}, "4", compareOptions{trim: TrimFull}, VerdictWA, -1, ""},
+		{"trailing newline", []string{"3"}, "3\n", compareOptions{trim: TrimFull}, VerdictAC, 0, ""},
+		{"crlf", []string{"1\n2"}, "1\r\n2\r\n", compareOptions{trim: TrimFull}, VerdictAC, 0, ""},
+		{"full trim", []string{"3"}, "  3  \n\n", compareOptions{trim: TrimFull}, VerdictAC, 0, ""},
+		{"blank-lines keeps spaces", []string{"3"}, "\n 3\n", compareOptions{trim: TrimBlankLines}, VerdictWA, -1, ""},
+		{"blank-lines trims blank lines", []string{"3"}, "\n\n3\n\n", compareOptions{trim: TrimBlankLines}, VerdictAC, 0, ""},
+		{"collapse-spaces tabs and spaces", []string{"1 2 3\n4 5"}, "1  2\t3\n \t4 \t 5\t", compareOptions{trim: TrimCollapseSpaces}, VerdictAC, 0, ""},
+		{"collapse-spaces blank line edges", []string{"1 2"}, "\n\n1\t2\n  \n", compareOptions{trim: TrimCollapseSpaces}, VerdictAC, 0, ""},
+		{"collapse-spaces keeps line breaks", []string{"1 2 3"}, "1 2\n3", compareOptions{trim: TrimCollapseSpaces}, VerdictWA, -1, ""},
+		{"collapse-spaces keeps inner blank lines", []string{"1\n2"}, "1\n\t\n2", compareOptions{trim: TrimCollapseSpaces}, VerdictWA, -1, ""},
+		{"collapse-spaces keeps tokens apart", []string{"12"}, "1 2", compareOptions{trim: TrimCollapseSpaces}, VerdictWA, -1, ""},
+		{"none keeps extra newline", []string{"3"}, "3\n\n", compareOptions{trim: TrimNone}, VerdictWA, -1, "outputs differ only by trailing newline"},
+		{"alternate", []string{"YES", "yes"}, "yes", compareOptions{trim: TrimFull}, VerdictAC, 1, ""},
+		{"no mismatch for alternates", []string{"1 2", "2 1"}, "1 3", compareOptions{tokens: true}, VerdictWA, -1, ""},
+		{"numeric equal", []string{"1000 0.5"}, "1e3 .5", compareOptions{tokens: true}, VerdictAC, 0, ""},
+		{"numeric differs", []string{"1 2"}, "1 3", compareOptions{tokens: true}, VerdictWA, -1, `token 2: expected "2", got "3"`},
+		{"token count", []string{"1 2"}, "1", compareOptions{tokens: true}, VerdictWA, -1, "expected 2 tokens, got 1"},
+		{"eps absolute", []string{"0.333333"}, "0.3333", compareOptions{tokens: true, eps: 1e-4}, VerdictAC, 0, ""},
+		{"eps relative", []string{"1000000"}, "1000001", compareOptions{tokens: true, eps: 1e-6}, VerdictAC, 0, ""},
+		{"eps exceeded", []string{"0.5"}, "0.6", compareOptions{tokens: true, eps: 1e-3}, VerdictWA, -1, `token 1: expected "0.5", got "0.6"`},
+		{"eps words", []string{"x 1"}, "y 1", compareOptions{tokens: true, eps: 1e-3}, VerdictWA, -1, `token 1: expected "x", got "y"`},
+		{"round", []string{"0.12 2"}, "0.1249 2.0004", compareOptions{tokens: true, rounded: true, decimals: 2}, VerdictAC, 0, ""},
+		{"round differs", []string{"1.5 0.124"}, "1.5 0.126", compareOptions{tokens: true, rounded: true, decimals: 2}, VerdictWA, -1, `token 2: expected "0.124", got "0.126" (0.12 and 0.13 once rounded)`},
+		{"round negative zero", []string{"0.00"}, "-0.001", compareOptions{tokens: true, rounded: true, decimals: 2}, VerdictAC, 0, ""},
+		{"round integers", []string{"3"}, "2.6", compareOptions{tokens: true, rounded: true}, VerdictAC, 0, ""},
+		{"int-float", []string{"5 -3 0 12345678901234567890"}, "5.0 -3.00 -0.0 12345678901234567890.", compareOptions{tokens: true, intFloat: true}, VerdictAC, 0, ""},
+		{"int-float strict", []string{"5 1000"}, "5.5 1e3", compareOptions{tokens: true, intFloat: true}, VerdictWA, -1, `token 1: expected "5", got "5.5"`},
+		{"leading zeros literal", []string{"7 5"}, "007 +5.0", compareOptions{tokens: true, intFloat: true}, VerdictWA, -1, `token 1: expected "7", got "007"`},
+		{"leading zeros", []string{"7 5 -0.5 x"}, "007 +5.0 -00.5 x", compareOptions{tokens: true, intFloat: true, leadingZeros: true}, VerdictAC, 0, ""},
+		{"leading zeros mismatch", []string{"08 1"}, "+7 1", compareOptions{tokens: true, intFloat: true, leadingZeros: true}, VerdictWA, -1, `token 1: expected "08", got "+7"`},
+		{"leading zeros eps", []string{"7 0.5"}, "007 +.50", compareOptions{tokens: true, eps: 1e-9}, VerdictAC, 0, ""},
+		{"leading zeros eps opt-in", []string{"7 0.5"}, "007 +.50", compareOptions{tokens: true, eps: 1e-9, leadingZeros: true}, VerdictAC, 0, ""},
+		{"leading zeros eps mismatch", []string{"7"}, "008", compareOptions{tokens: true, eps: 1e-9, leadingZeros: true}, VerdictWA, -1, `token 1: expected "7", got "008"`},
+		{"leading zeros round", []string{"0012.345"}, "12.35", compareOptions{tokens: true, rounded: true, decimals: 2, leadingZeros: true}, VerdictAC, 0, ""},
+		{"int-float exponent", []string{"1000"}, "1e3", compareOptions{tokens: true, intFloat: true}, VerdictWA, -1, `token 1: expected "1000", got "1e3"`},
+		{"delims", []string{"1,2,3"}, "1, 2 ,3", compareOptions{tokens: true, delims: ","}, VerdictAC, 0, ""},
+		{"rows", []string{"1 2\n3 4"}, "1.0 2\n3 4.00001", compareOptions{tokens: true, rows: true, eps: 1e-4}, VerdictAC, 0, ""},
+		{"rows layout", []string{"1 2\n3 4"}, "1 2 3\n4", compareOptions{tokens: true, rows: true}, VerdictWA, -1, "row 1: expected 2 tokens, got 3"},
+		{"rows count", []string{"1\n2"}, "1 2", compareOptions{tokens: true, rows: true}, VerdictWA, -1, "expected 2 rows, got 1"},
+		{"rows token", []string{"1 2\n3 4"}, "1 2\n3 5", compareOptions{tokens: true, rows: true}, VerdictWA, -1, `row 2 token 2: expected "4", got "5"`},
+		{"contains", []string{"42"}, "Answer: 42\nDone", compareOptions{trim: TrimFull, contains: true}, VerdictAC, 0, ""},
+		{"contains trims expected", []string{"\n42\n"}, "The answer is 42.", compareOptions{trim: TrimFull, contains: true}, VerdictAC, 0, ""},
+		{"contains closest", []string{"answer 42"}, "log\nanswer 41\n", compareOptions{trim: TrimFull, contains: true}, VerdictWA, -1, `expected output not found, closest at line 2: "answer 41"`},
+		{"contains none", []string{"x"}, "abc", compareOptions{trim: TrimFull, contains: true}, VerdictWA, -1, "expected output not found"},
+		{"multiset", []string{"3 1 2\n1"}, "1 1\n2 3", compareOptions{multiset: true}, VerdictAC, 0, ""},
+		{"multiset counts", []string{"5 5 5 1"}, "5 1 5 7", compareOptions{multiset: true}, VerdictWA, -1, `"5" expected 3 times, got 2; "7" expected 0 times, got 1`},
+		{"multiset literal", []string{"1"}, "1.0", compareOptions{multiset: true}, VerdictWA, -1, `"1" expected once, got 0; "1.0" expected 0 times, got 1`},
+		{"multiset many", []string{"a b c d"}, "e", compareOptions{multiset: true}, VerdictWA, -1, `"a" expected once, got 0; "b" expected once, got 0; "c" expected once, got 0; 2 more tokens differ`},
+		{"comments", []string{"# header\n1\n2"}, "1\n  # debug\n2\n", compareOptions{trim: TrimFull, commentPrefix: "#"}, VerdictAC, 0, ""},
+		{"comments blank-lines", []string{"1\n//x\n"}, "//y\n1", compareOptions{trim: TrimBlankLines, commentPrefix: "//"}, VerdictAC, 0, ""},
+		{"comments kept", []string{"1"}, "1\n# debug", compareOptions{trim: TrimFull}, VerdictWA, -1, ""},
+		{"columns", []string{"1 a 10\n2 b 20"}, "1 x 10\n2 y  20", compareOptions{trim: TrimFull, columns: []int{1, 3}}, VerdictAC, 0, ""},
+		{"columns differ", []string{"1 a 10"}, "1 a 11", compareOptions{trim: TrimFull, columns: []int{3}}, VerdictWA, -1, ""},
+		{"columns delims", []string{"id,name,score\n1,ann,3"}, "id, nm ,score\n1,bo,3", compareOptions{trim: TrimFull, columns: []int{1, 3}, delims: ","}, VerdictAC, 0, ""},
+		{"columns missing", []string{"1 2 3"}, "1 2", compareOptions{trim: TrimFull, columns: []int{3}}, VerdictWA, -1, "output line 1 has 2 columns, column 3 is missing"},
+		{"columns tokens", []string{"a 1.0"}, "b 1", compareOptions{tokens: true, columns: []int{2}}, VerdictAC, 0, ""},
+		{"whitespace rejected", []string{"1 2"}, "1  2", compareOptions{trim: TrimFull}, VerdictWA, -1, ""},
+		{"whitespace accepted", []string{"1 2"}, "1  2", compareOptions{trim: TrimFull, whitespace: true}, VerdictAC, 0, ""},
+		{"binary exact", []string{"\x00\x01"}, "\x00\x01", compareOptions{binary: true}, VerdictAC, 0, ""},
+		{"binary trailing newline", []string{"\x00\x01"}, "\x00\x01\n", compareOptions{binary: true}, VerdictWA, -1, "expected 2 bytes, got 3 bytes with the same prefix"},
+		{"binary differs", []string{"abc"}, "abd", compareOptions{binary: true}, VerdictWA, -1, "first difference at byte offset 2, 0x2"},
+		{"max-edits", []string{"Hello, world"}, "Helo world!\n", compareOptions{trim: TrimFull, fuzzy: true, maxEdits: 3}, VerdictAC, 0, ""},
+		{"max-edits exceeded", []string{"kitten"}, "sitting", compareOptions{trim: TrimFull, fuzzy: true, maxEdits: 2}, VerdictWA, -1, "edit distance 3 is over the -max-edits 2"},
+		{"max-edits zero", []string{"abc"}, "abd", compareOptions{trim: TrimFull, fuzzy: true}, VerdictWA, -1, "edit distance 1 is over the -max-edits 0"},
+		{"max-edits runes", []string{"héllo"}, "hello", compareOptions{trim: TrimFull, fuzzy: true, maxEdits: 1}, VerdictAC, 0, ""},
+		{"extra lines", []string{"3\n"}, "3\nTotal: 1 query\n", compareOptions{trim: TrimNone, extraLines: true}, VerdictAC, 0, ""},
+		{"extra lines tokens", []string{"1 2\n3\n"}, "1 2.0\n3\ndone in 5ms\n", compareOptions{trim: TrimFull, tokens: true, extraLines: true}, VerdictAC, 0, ""},
+		{"extra lines short output", []string{"3\n4\n"}, "3\n", compareOptions{trim: TrimFull, extraLines: true}, VerdictWA, -1, ""},
+		{"extra lines wrong answer", []string{"3\n"}, "4\n3\n", compareOptions{trim: TrimFull, extraLines: true}, VerdictWA, -1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := judge(tt.expected, tt.actual, tt.opts)
+			if got.verdict != tt.verdict || got.matched != tt.matched || got.mismatch != tt.mismatch {
+				t.Errorf("judge(%q, %q) = %s (matched %d, mismatch %q), want %s (matched %d, mismatch %q)",
+					tt.expected, tt.actual, got.verdict, got.matched, got.mismatch, tt.verdict, tt.matched, tt.mismatch)
+			}
+		})
+	}
+}
+
+func TestDropExtraLines(t *testing.T) {
+	tests := []struct {
+		expected, actual, want string
+		dropped                int
+	}{
+		{"3\n", "3\nsummary\n", "3\n", 1},
+		{"1\n2", "1\n2\nx\ny\n\n\n", "1\n2\n", 2},
+		{"3\n", "3\n\n", "3\n\n", 0},
+		{"", "debug\n", "", 1},
+		{"", "\n\n", "\n\n", 0},
+		{"1\n2\n3\n", "1\n", "1\n", 0},
+	}
+	for _, tt := range tests {
+		got, dropped := dropExtraLines(tt.expected, tt.actual)
+		if got != tt.want || dropped != tt.dropped {
+			t.Errorf("dropExtraLines(%q, %q) = %q, %d; want %q, %d", tt.expected, tt.actual, got, dropped, tt.want, tt.dropped)
+		}
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	tests := map[string]string{
+		"plain\n":                                   "plain\n",
+		"\x1b[31m3\x1b[0m\n":                        "3\n",
+		"\x1b[1;38;5;208mbold\x1b[m 4":              "bold 4",
+		"\x1b[2K\x1b[1Gdone":                        "done",
+		"\x1b]0;title\x07answer":                    "answer",
+		"\x1b]8;;https://x\x1b\\link\x1b]8;;\x1b\\": "link",
+		"\x1bMup":                                   "up",
+	}
+	for output, want := range tests {
+		if got := stripANSI(output); got != want {
+			t.Errorf("stripANSI(%q) = %q, want %q", output, got, want)
+		}
+	}
+}
+
+func TestStripLeadingZeros(t *testing.T) {
+	tests := map[string]string{
+		"007": "7", "+7": "7", "-007": "-7", "000": "0", "+00.50": "0.50", "-.5": "-.5",
+		"0x10": "0x10", "1e3": "1e3", "abc": "abc", "+": "+", "007a": "007a", "12": "12",
+	}
+	for token, want := range tests {
+		if got := stripLeadingZeros(token); got != want {
+			t.Errorf("stripLeadingZeros(%q) = %q, want %q", token, got, want)
+		}
+	}
+}
+
+func TestCheckSorted(t *testing.T) {
+	tests := []struct {
+		output, unit, delims string
+		want                 string
+	}{
+		{"1\n2\n2\n10\n", SortedLines, "", ""},
+		{"", SortedLines, "", ""},
+		{"apple\n\nbanana\n  cherry", SortedLines, "", ""},
+		{"1\n10\n3\n", SortedLines, "", `line 3 ("3") comes after "10"`},
+		{"-1.5 -1 0 2e3", SortedTokens, "", ""},
+		{"1 2\n3 2", SortedTokens, "", `token 4 ("2") comes after "3"`},
+		{"b a", SortedLines, "", ""},
+		{"b\na", SortedLines, "", `line 2 ("a") comes after "b"`},
+		// Tokens are split by -delim like the token comparisons split them
+		{"1,2,10\n11", SortedTokens, ",", ""},
+		{"new york,boston", SortedTokens, ",", `token 2 ("boston") comes after "new york"`},
+		{"1,2,10", SortedTokens, "", ""},
+	}
+	for _, tt := range tests {
+		ok, got := checkSorted(tt.output, tt.unit, tt.delims)
+		if ok != (tt.want == "") || got != tt.want {
+			t.Errorf("checkSorted(%q, %s, %q) = %v, %q; want %q", tt.output, tt.unit, tt.delims, ok, got, tt.want)
+		}
+	}
+}
+
+func TestBoundedEditDistance(t *testing.T) {
+	// Every bound is checked against the full quadratic table
+	full := func(a, b []rune) int {
+		row := make([]int, len(b)+1)
+		for j := range row {
+			row[j] = j
+		}
+		for i := 1; i <= len(a); i++ {
+			diagonal := row[0]
+			row[0] = i
+			for j := 1; j <= len(b); j++ {
+				cost := diagonal
+				if a[i-1] != b[j-1] {
+					cost++
+				}
+				if row[j]+1 < cost {
+					cost = row[j] + 1
+				}
+				if row[j-1]+1 < cost {
+					cost = row[j-1] + 1
+				}
+				diagonal, row[j] = row[j], cost
+			}
+		}
+		return row[len(b)]
+	}
+	words := []string{"", "a", "ab", "kitten", "sitting", "saturday", "sunday", "abcabcabc", "cbacbacba", "aaaa", "bbbbbbb"}
+	for _, x := range words {
+		for _, y := range words {
+			a, b := []rune(x), []rune(y)
+			want := full(a, b)
+			for bound := 0; bound <= 10; bound++ {
+				got, ok := boundedEditDistance(a, b, bound)
+				if ok != (want <= bound) || ok && got != want {
+					t.Errorf("boundedEditDistance(%q, %q, %d) = %d, %v; distance is %d", x, y, bound, got, ok, want)
+				}
+			}
+		}
+	}
+}
+
+func TestJudgePrefersExactMatch(t *testing.T) {
+	got := judge([]string{"1  2", "1 2"}, "1 2", compareOptions{trim: TrimFull, whitespace: true})
+	if got.matched != 1 || got.whitespaceOnly {
+		t.Errorf("got matched %d (whitespace only: %v), want the exact match 1", got.matched, got.whitespaceOnly)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		output, delims string
+		want           []string
+	}{
+		{"1 2\n3", "", []string{"1", "2", "3"}},
+		{"a,b;c", ",;", []string{"a", "b", "c"}},
+		{"a, ,b\nc", ",", []string{"a", "b", "c"}},
+		{"", ",", nil},
+	}
+	for _, tt := range tests {
+		got := tokenize(tt.output, tt.delims)
+		if len(got) != len(tt.want) {
+			t.Errorf("tokenize(%q, %q) = %q, want %q", tt.output, tt.delims, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("tokenize(%q, %q) = %q, want %q", tt.output, tt.delims, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestDiffSnippet(t *testing.T) {
+	got := diffSnippet("a.out", "1\n2\n3", "1\n5\n3")
+	want := "First difference at a.out:2\n- expected: 2\n+ actual:   5"
+	if got != want {
+		t.Errorf("diffSnippet() = %q, want %q", got, want)
+	}
+}
+
+func TestHexDump(t *testing.T) {
+	got := hexDump("hello\x00", 0)
+	want := "00000000  68 65 6c 6c 6f 00                                 |hello.|"
+	if got != want {
+		t.Errorf("hexDump() = %q, want %q", got, want)
+	}
+}
+
+func TestShowWhitespace(t *testing.T) {
+	dmp := diffmatchpatch.New()
+	diffs := showWhitespace(dmp.DiffMain("1 2\n3", "1\t2 \n3", false))
+	if got, want := dmp.DiffText2(diffs), "1→2·¶\n3"; got != want {
+		t.Errorf("showWhitespace() actual text = %q, want %q", got, want)
+	}
+	if got, want := dmp.DiffText1(diffs), "1·2¶\n3"; got != want {
+		t.Errorf("showWhitespace() expected text = %q, want %q", got, want)
+	}
+}
+
+func TestDiffOutputs(t *testing.T) {
+	dmp := diffmatchpatch.New()
+	expected, actual := "the quick fox\njumps", "the quack fox\njumps"
+	tests := []struct {
+		granularity string
+		deleted     []string
+	}{
+		{DiffChar, []string{"i"}},
+		{DiffWord, []string{"quick"}},
+		{DiffLine, []string{"the quick fox\n"}},
+	}
+	for _, tt := range tests {
+		diffs := diffOutputs(dmp, expected, actual, tt.granularity)
+		if dmp.DiffText1(diffs) != expected || dmp.DiffText2(diffs) != actual {
+			t.Errorf("%s: diff doesn't rebuild both outputs: %v", tt.granularity, diffs)
+		}
+		var deleted []string
+		for _, diff := range diffs {
+			if diff.Type == diffmatchpatch.DiffDelete {
+				deleted = append(deleted, diff.Text)
+			}
+		}
+		if strings.Join(deleted, "|") != strings.Join(tt.deleted, "|") {
+			t.Errorf("%s: deleted %q, want %q", tt.granularity, deleted, tt.deleted)
+		}
+	}
+}
+
+func TestTruncateLines(t *testing.T) {
+	tests := []struct {
+		text      string
+		maxLines  int
+		want      string
+		truncated bool
+	}{
+		{"a\nb\nc", 0, "a\nb\nc", false},
+		{"a\nb\nc", 3, "a\nb\nc", false},
+		{"a\nb\nc\n", 3, "a\nb\nc\n", false},
+		{"a\nb\nc", 2, "a\nb" + Reset, true},
+		{"a\nb\nc\nd", 1, "a" + Reset, true},
+	}
+	for _, tt := range tests {
+		got, truncated := truncateLines(tt.text, tt.maxLines)
+		if got != tt.want || truncated != tt.truncated {
+			t.Errorf("truncateLines(%q, %d) = %q, %v, want %q, %v", tt.text, tt.maxLines, got, truncated, tt.want, tt.truncated)
+		}
+	}
+}
@@ -0,0 +1,915 @@
+package harn
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Chunk sizes and delays used by -slow-stdin
+const (
+	trickleMaxChunk = 64
+	trickleMaxDelay = 10 * time.Millisecond
+)
+
+// trickleReader returns data in small, randomly sized chunks after a short random
+// delay, so a program sees its input arrive piece by piece like on an interactive judge
+type trickleReader struct {
+	r   io.Reader
+	rng *rand.Rand
+}
+
+func newTrickleReader(r io.Reader, seed int64) *trickleReader {
+	return &trickleReader{r: r, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (t *trickleReader) Read(p []byte) (int, error) {
+	if n := 1 + t.rng.Intn(trickleMaxChunk); len(p) > n {
+		p = p[:n]
+	}
+	time.Sleep(time.Duration(t.rng.Int63n(int64(trickleMaxDelay))))
+	return t.r.Read(p)
+}
+
+// testInput returns the input of a test: the inline input of an inline case, or else the
+// content of its input file
+func testInput(inputFile string, opts execOptions) (string, error) {
+	if opts.input != nil {
+		return *opts.input, nil
+	}
+	input, err := readInput(inputFile, opts.timeout)
+	if err == nil && opts.descriptionLine {
+		input = stripDescription(input)
+	}
+	return input, err
+}
+
+// readInput returns the input for a test. For .cmd files, the file holds a shell
+// command whose output is used as the input.
+func readInput(inputFile string, timeout time.Duration) (string, error) {
+	if !strings.HasSuffix(inputFile, ".cmd") {
+		inputContent, err := readFile(inputFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read input file: %v", err)
+		}
+		return inputContent, nil
+	}
+
+	command, err := readFile(inputFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read input command: %v", err)
+	}
+	return pipeThrough(command, "", timeout, "input command")
+}
+
+// appendInput appends the -stdin-append suffix to an input. readFile drops the final line
+// break of input files, so it is put back first and the suffix starts on a line of its own.
+func appendInput(input, suffix string) string {
+	if suffix == "" {
+		return input
+	}
+	if input != "" && !strings.HasSuffix(input, "\n") {
+		input += "\n"
+	}
+	return input + suffix
+}
+
+// pipeThrough runs a shell command with the given stdin and returns its stdout.
+// The purpose names the command in errors, e.g. "input command".
+func pipeThrough(command, stdin string, timeout time.Duration, purpose string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := shellCommand(ctx, command)
+	cmd.Stdin = strings.NewReader(stdin)
+	stderr := newCappedBuffer(defaultStderrBuffer)
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("%s timed out after %v", purpose, timeout)
+	} else if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s failed: %v: %s", purpose, err, msg)
+		}
+		return "", fmt.Errorf("%s failed: %v", purpose, err)
+	}
+	return string(output), nil
+}
+
+// shellCommand runs a command line through the platform's shell
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// expectedCmdLabel names the -expected-cmd command in messages, in place of an expected file
+const expectedCmdLabel = "expected command"
+
+// expandInputPath substitutes {input} in a command line with the quoted path of the test's input file
+func expandInputPath(command, inputFile string) string {
+	quoted := shellQuote(inputFile)
+	if runtime.GOOS == "windows" {
+		quoted = `"` + inputFile + `"`
+	}
+	return strings.ReplaceAll(command, "{input}", quoted)
+}
+
+// shellQuote quotes a word for a POSIX shell, leaving words that need no quoting as they are
+func shellQuote(word string) string {
+	if word != "" && strings.Trim(word, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_./-+=:,@%") == "" {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// printfFormat escapes a string into a printf format that prints it verbatim, with
+// control characters written as escapes so the command stays on one line
+func printfFormat(s string) string {
+	var format strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case b == '\\':
+			format.WriteString(`\\`)
+		case b == '%':
+			format.WriteString("%%")
+		case b == '\n':
+			format.WriteString(`\n`)
+		case b == '\t':
+			format.WriteString(`\t`)
+		case b < 0x20 || b == 0x7f:
+			fmt.Fprintf(&format, `\%03o`, b)
+		default:
+			format.WriteByte(b)
+		}
+	}
+	return format.String()
+}
+
+// ulimitCommand sets the soft limits of a shell: the CPU time rounded up to whole
+// seconds and the address space in KiB. Zero limits are left alone.
+func ulimitCommand(cpuLimit time.Duration, memLimit int64) string {
+	var limits []string
+	if cpuLimit > 0 {
+		limits = append(limits, fmt.Sprintf("ulimit -S -t %d", int64((cpuLimit+time.Second-1)/time.Second)))
+	}
+	if memLimit > 0 {
+		limits = append(limits, fmt.Sprintf("ulimit -S -v %d", (memLimit+1023)/1024))
+	}
+	return strings.Join(limits, " && ")
+}
+
+// reproCommand builds a shell command line that runs the program on one test the
+// way harn does, for pasting into a terminal to reproduce a failure
+func reproCommand(programPath, inputFile string, opts execOptions) string {
+	argv := append(append([]string{}, opts.interpreter...), programPath)
+	// The seed of -seed-arg input is left out should the input fail to load
+	inputContent := ""
+	if opts.seedArg == SeedArgInput {
+		inputContent, _ = testInput(inputFile, opts)
+	}
+	if args, err := programArgs(inputContent, opts); err == nil {
+		argv = append(argv, args...)
+	}
+	var quoted []string
+	for _, arg := range argv {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	program := strings.Join(quoted, " ")
+	var setup []string
+	if opts.dir != "" {
+		setup = append(setup, "cd "+shellQuote(opts.dir))
+	}
+	if opts.cpuLimit > 0 || opts.memLimit > 0 {
+		setup = append(setup, ulimitCommand(opts.cpuLimit, opts.memLimit))
+	}
+	if len(setup) > 0 {
+		program = "(" + strings.Join(setup, " && ") + " && exec " + program + ")"
+	}
+
+	// The input is redirected from the file unless it has to be generated or transformed on the way
+	var feed []string
+	redirect := " < " + shellQuote(inputFile)
+	if opts.input != nil {
+		feed, redirect = append(feed, "printf %s "+shellQuote(*opts.input)), ""
+	} else if strings.HasSuffix(inputFile, ".cmd") {
+		feed, redirect = append(feed, "sh "+shellQuote(inputFile)), ""
+	} else if opts.descriptionLine {
+		feed, redirect = append(feed, "tail -n +2 "+shellQuote(inputFile)), ""
+	}
+	if opts.pre != "" {
+		feed = append(feed, "sh -c "+shellQuote(opts.pre)+redirect)
+		redirect = ""
+	}
+	if opts.inputSuffix != "" {
+		// awk 1 ends the input with a line break like appendInput, unless it is empty
+		feed = append(feed, "awk 1"+redirect)
+		redirect = ""
+		feed = []string{"{ " + strings.Join(feed, " | ") + "; printf " + shellQuote(printfFormat(opts.inputSuffix)) + "; }"}
+	}
+
+	command := program + redirect
+	if len(feed) > 0 {
+		command = strings.Join(feed, " | ") + " | " + program
+	}
+	if opts.post != "" {
+		command += " | sh -c " + shellQuote(opts.post)
+	}
+	return command
+}
+
+// execOptions controls how executeProgram runs the program for a single test
+type execOptions struct {
+	inputSuffix string
+	timeout     time.Duration
+	// cpuLimit is the CPU time after which the program is killed, zero for no limit
+	cpuLimit time.Duration
+	// memLimit caps the address space of the program in bytes, zero for no limit
+	memLimit int64
+	// idleTimeout kills the program once it goes this long without writing to stdout, zero for no limit
+	idleTimeout time.Duration
+	// outputFile is the file the program writes its output to, relative to a fresh
+	// working directory for each run, instead of stdout. Empty means stdout.
+	outputFile string
+	// input replaces the content of the input file, for inline cases
+	input *string
+	// descriptionLine removes the -inline-desc description on the first line of the input file
+	descriptionLine bool
+	// outputDir compares the whole working directory the program leaves behind, serialized by readTree
+	outputDir   bool
+	hash        bool
+	perf        bool
+	interpreter []string
+	dir         string
+	temp        *tempStore
+	slowStdin   bool
+	// okCodes lists the exit codes that count as a successful run
+	okCodes map[int]bool
+	// pre is a shell command that transforms each input before the program reads it
+	pre string
+	// post is a shell command that transforms the program's output before it is compared or hashed
+	post string
+	seed int64
+	// args are passed to the program after its path, as given after -- on the command line
+	args []string
+	// seedArg appends a seed to args: the test's index under SeedArgIndex, the first token of
+	// its input under SeedArgInput, nothing when empty
+	seedArg   string
+	seedIndex int
+	// captureStderr collects what the program writes to stderr, which is discarded otherwise
+	captureStderr bool
+	// stderrLimit is how many bytes of the captured stderr are kept, defaultStderrBuffer when zero
+	stderrLimit int64
+	// cpus hands out the CPU each program is pinned to under -pin, nil when not pinning
+	cpus *cpuPool
+	// strictInput watches how the program reads stdin, to explain timeouts
+	strictInput bool
+}
+
+// cpuPool hands out CPUs to pin programs to, one per running test. Tests wait for
+// a free CPU rather than share one, which would distort their times.
+type cpuPool struct {
+	cpus chan int
+}
+
+func newCPUPool(cpus []int) *cpuPool {
+	p := &cpuPool{cpus: make(chan int, len(cpus))}
+	for _, cpu := range cpus {
+		p.cpus <- cpu
+	}
+	return p
+}
+
+func (p *cpuPool) acquire() int {
+	return <-p.cpus
+}
+
+func (p *cpuPool) release(cpu int) {
+	p.cpus <- cpu
+}
+
+// execResult is the outcome of a single successful program execution
+type execResult struct {
+	input     string
+	output    string
+	time      time.Duration
+	perfStats string
+	// stderr is what the program wrote to stderr, when captured
+	stderr string
+	// hangAfterRead is set on timeouts where all the input was delivered but
+	// nothing was written, which usually means the program blocked after reading
+	hangAfterRead bool
+	// cpuLimitExceeded is set on timeouts where the program used up its CPU time limit
+	cpuLimitExceeded bool
+	// idle is set on timeouts where the program stopped writing output for the idle timeout
+	idle bool
+	// waitingForInput is set under -strict-input on timeouts where the program kept
+	// reading stdin after the end of its input, inputRead where it had read all of it
+	// but was busy with something else
+	waitingForInput bool
+	inputRead       bool
+	// outputSize is the number of bytes the program wrote, before any postprocessing or hashing
+	outputSize int64
+}
+
+// Seed sources accepted by Config.SeedArg
+const (
+	// SeedArgIndex passes the test's 1-based position among the tests found
+	SeedArgIndex = "index"
+	// SeedArgInput passes the first whitespace-separated token of the test's input
+	SeedArgInput = "input"
+)
+
+var validSeedArgs = map[string]bool{"": true, SeedArgIndex: true, SeedArgInput: true}
+
+// programArgs gives the arguments the program runs with: those forwarded after --,
+// followed by the seed of -seed-arg
+func programArgs(inputContent string, opts execOptions) ([]string, error) {
+	args := append([]string{}, opts.args...)
+	switch opts.seedArg {
+	case SeedArgIndex:
+		args = append(args, strconv.Itoa(opts.seedIndex))
+	case SeedArgInput:
+		fields := strings.Fields(inputContent)
+		if len(fields) == 0 {
+			return nil, errors.New("the input is empty, there is no seed for -seed-arg input")
+		}
+		args = append(args, fields[0])
+	}
+	return args, nil
+}
+
+// defaultStderrBuffer is how much of a command's stderr is kept unless -stderr-buffer says otherwise
+const defaultStderrBuffer = 1 << 20
+
+// cappedBuffer keeps the first limit bytes written to it and counts the rest. Writes
+// never fail or block, so a child writing lots to stderr is always drained whatever
+// the limit, and never fills the pipe while we wait on its stdout.
+type cappedBuffer struct {
+	limit   int64
+	buf     bytes.Buffer
+	dropped int64
+}
+
+func newCappedBuffer(limit int64) *cappedBuffer {
+	return &cappedBuffer{limit: limit}
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	keep := b.limit - int64(b.buf.Len())
+	if keep < 0 {
+		keep = 0
+	}
+	if int64(len(p)) < keep {
+		keep = int64(len(p))
+	}
+	b.buf.Write(p[:keep])
+	b.dropped += int64(len(p)) - keep
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	if b.dropped == 0 {
+		return b.buf.String()
+	}
+	return fmt.Sprintf("%s\n... (%sB more not kept, see -stderr-buffer)\n", b.buf.String(), FormatMemory(b.dropped))
+}
+
+// outputActivity records when the program last wrote to stdout, for -idle-timeout
+type outputActivity struct {
+	lastWrite int64 // UnixNano, accessed atomically
+}
+
+func newOutputActivity() *outputActivity {
+	return &outputActivity{lastWrite: time.Now().UnixNano()}
+}
+
+func (a *outputActivity) Write(p []byte) (int, error) {
+	atomic.StoreInt64(&a.lastWrite, time.Now().UnixNano())
+	return len(p), nil
+}
+
+// watch calls kill once no output was written for idle, until done is closed
+func (a *outputActivity) watch(idle time.Duration, done <-chan struct{}, kill func()) {
+	interval := idle / 10
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, atomic.LoadInt64(&a.lastWrite))) >= idle {
+				kill()
+				return
+			}
+		}
+	}
+}
+
+// ioCounters are a process's read syscall count and the bytes those reads returned
+type ioCounters struct {
+	reads int64
+	bytes int64
+}
+
+// readWatchSamples is how many of the latest I/O counter samples -strict-input looks at
+const readWatchSamples = 4
+
+// readWatch samples the I/O counters of the program under -strict-input, to tell a
+// program that keeps reading stdin after its end from one that is busy computing
+type readWatch struct {
+	mu      sync.Mutex
+	samples []ioCounters
+}
+
+// watch samples the counters of pid every interval until done is closed or ctx ends,
+// stopping early once they can't be read, e.g. because the program exited
+func (w *readWatch) watch(ctx context.Context, pid int, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// Samples taken while the program is being killed are unreliable
+		if ctx.Err() != nil {
+			return
+		}
+		counters, err := readIOCounters(pid)
+		if err != nil {
+			return
+		}
+		w.mu.Lock()
+		w.samples = append(w.samples, counters)
+		if len(w.samples) > readWatchSamples {
+			w.samples = w.samples[1:]
+		}
+		w.mu.Unlock()
+	}
+}
+
+// readingPastEOF reports whether every latest sample found more read calls but no
+// more bytes read, which means the program keeps reading at the end of its input
+func (w *readWatch) readingPastEOF() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.samples) < readWatchSamples {
+		return false
+	}
+	for i := 1; i < len(w.samples); i++ {
+		if w.samples[i].reads <= w.samples[i-1].reads || w.samples[i].bytes != w.samples[i-1].bytes {
+			return false
+		}
+	}
+	return true
+}
+
+// readWatchInterval spreads the samples over the last part of the timeout
+func readWatchInterval(timeout time.Duration) time.Duration {
+	interval := timeout / 20
+	if interval < 5*time.Millisecond {
+		interval = 5 * time.Millisecond
+	} else if interval > 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	return interval
+}
+
+// inputConsumed reports whether all the input was written to the pipe and the
+// program read it back out. Where the unread byte count isn't available, having
+// written everything is taken as consumed.
+func inputConsumed(stdinRead *os.File, delivered chan struct{}) bool {
+	select {
+	case <-delivered:
+	default:
+		return false
+	}
+	unread, err := pendingBytes(stdinRead)
+	return err != nil || unread == 0
+}
+
+// exitCodeError is returned when the program exits with a code that isn't in -ok-codes
+type exitCodeError struct {
+	code  int
+	state *os.ProcessState
+}
+
+func (e *exitCodeError) Error() string {
+	if e.code < 0 {
+		// Killed by a signal, the process state names it
+		return fmt.Sprintf("terminated abnormally (%v)", e.state)
+	}
+	return fmt.Sprintf("exited with code %d", e.code)
+}
+
+// timeoutMessage describes a timeout, pointing out programs that likely hang after reading their input
+func timeoutMessage(result execResult, opts execOptions) string {
+	if result.cpuLimitExceeded {
+		return fmt.Sprintf("Program exceeded %v CPU time limit", opts.cpuLimit)
+	}
+	if result.idle {
+		return fmt.Sprintf("Program wrote no output for %v (idle timeout)", opts.idleTimeout)
+	}
+	if result.waitingForInput {
+		return fmt.Sprintf("Program exceeded %v timeout waiting for more input (it kept reading stdin after the end of its input)", opts.timeout)
+	}
+	if result.inputRead {
+		return fmt.Sprintf("Program exceeded %v timeout computing (it read all of its input and stopped reading)", opts.timeout)
+	}
+	if result.hangAfterRead {
+		return fmt.Sprintf("Program exceeded %v timeout (no output, likely hang after read)", opts.timeout)
+	}
+	return fmt.Sprintf("Program exceeded %v timeout", opts.timeout)
+}
+
+// timeoutVerdict labels a timeout as TLE, or TLE (cpu) and TLE (idle) when the
+// CPU time limit or the idle timeout killed the program
+func timeoutVerdict(result execResult) string {
+	if result.cpuLimitExceeded {
+		return VerdictTLE + " (cpu)"
+	}
+	if result.idle {
+		return VerdictTLE + " (idle)"
+	}
+	return VerdictTLE
+}
+
+// executor runs the program under test, it is an interface so tests can substitute a fake program
+type executor interface {
+	// check verifies that the program can be run at all
+	check(programPath string, interpreter []string) error
+	// execute runs the program on a single input
+	execute(programPath, inputFile string, opts execOptions) (execResult, error)
+}
+
+// processExecutor runs the program as a child process
+type processExecutor struct{}
+
+func (processExecutor) check(programPath string, interpreter []string) error {
+	return checkProgram(programPath, interpreter)
+}
+
+func (processExecutor) execute(programPath, inputFile string, opts execOptions) (execResult, error) {
+	return executeProgram(programPath, inputFile, opts)
+}
+
+// executeProgram runs the program on a test. Only the program itself is timed: preparing its
+// input beforehand and reading, postprocessing or hashing its output afterwards are logged
+// separately at debug level, and don't count towards its execution time or timeout.
+func executeProgram(programPath, inputFile string, opts execOptions) (execResult, error) {
+	setupStart := time.Now()
+	inputContent, err := testInput(inputFile, opts)
+	if err != nil {
+		return execResult{}, err
+	}
+	if opts.pre != "" {
+		if inputContent, err = pipeThrough(opts.pre, inputContent, opts.timeout, "preprocessing command"); err != nil {
+			return execResult{}, err
+		}
+	}
+	inputContent = appendInput(inputContent, opts.inputSuffix)
+	setupTime := time.Since(setupStart)
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+	// The idle timeout cancels the program's context, telling it apart from the overall timeout
+	var activity *outputActivity
+	var idleExceeded int32
+	if opts.idleTimeout > 0 {
+		activity = newOutputActivity()
+		var cancelIdle context.CancelFunc
+		ctx, cancelIdle = context.WithCancel(ctx)
+		defer cancelIdle()
+		done := make(chan struct{})
+		defer close(done)
+		go activity.watch(opts.idleTimeout, done, func() {
+			atomic.StoreInt32(&idleExceeded, 1)
+			cancelIdle()
+		})
+	}
+
+	args, err := programArgs(inputContent, opts)
+	if err != nil {
+		return execResult{}, err
+	}
+	argv := append(append(append([]string{}, opts.interpreter...), programPath), args...)
+	var perfFile string
+	if opts.perf {
+		// perf stat writes its summary to a separate file so it doesn't mix with the program's output
+		file, err := opts.temp.create(inputFile, "perf")
+		if err != nil {
+			return execResult{}, fmt.Errorf("failed to create perf output file: %v", err)
+		}
+		perfFile = file.Name()
+		file.Close()
+		defer opts.temp.remove(perfFile)
+		argv = append([]string{"perf", "stat", "-o", perfFile, "--"}, argv...)
+	}
+	if opts.cpuLimit > 0 || opts.memLimit > 0 {
+		argv = withLimits(argv, opts.cpuLimit, opts.memLimit)
+	}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = opts.dir
+	if opts.outputFile != "" || opts.outputDir {
+		// The program runs in a directory of its own, so output files of different tests never mix
+		if cmd.Dir, err = opts.temp.mkdir(inputFile, "cwd"); err != nil {
+			return execResult{}, fmt.Errorf("failed to create working directory: %v", err)
+		}
+		defer opts.temp.remove(cmd.Dir)
+	}
+	// os/exec copies stderr into the buffer from a goroutine of its own, so it's drained
+	// while we read stdout on either path below. Uncaptured stderr goes to the null device.
+	stderrLimit := opts.stderrLimit
+	if stderrLimit == 0 {
+		stderrLimit = defaultStderrBuffer
+	}
+	stderr := newCappedBuffer(stderrLimit)
+	if opts.captureStderr {
+		cmd.Stderr = stderr
+	}
+	// Feeding stdin through our own pipe lets us tell afterwards whether the
+	// program read all of its input, not just whether it fit in the pipe buffer
+	stdinRead, stdinWrite, err := os.Pipe()
+	if err != nil {
+		return execResult{}, fmt.Errorf("failed to create stdin pipe: %v", err)
+	}
+	defer stdinRead.Close()
+	cmd.Stdin = stdinRead
+	var stdin io.Reader = strings.NewReader(inputContent)
+	if opts.slowStdin {
+		stdin = newTrickleReader(stdin, opts.seed)
+	}
+	startCommand := (*exec.Cmd).Start
+	if opts.cpus != nil {
+		cpu := opts.cpus.acquire()
+		defer opts.cpus.release(cpu)
+		startCommand = func(cmd *exec.Cmd) error {
+			return startPinned(cmd, cpu)
+		}
+	}
+	var reads *readWatch
+	// Under -perf the process is perf itself, whose reads say nothing about the program
+	if opts.strictInput && !opts.perf {
+		reads = &readWatch{}
+		done := make(chan struct{})
+		defer close(done)
+		startProgram := startCommand
+		startCommand = func(cmd *exec.Cmd) error {
+			err := startProgram(cmd)
+			if err == nil {
+				go reads.watch(ctx, cmd.Process.Pid, readWatchInterval(opts.timeout), done)
+			}
+			return err
+		}
+	}
+
+	start := time.Now()
+	delivered := make(chan struct{})
+	go func() {
+		// The write fails once stdinRead is closed, should the program stop reading early
+		io.Copy(stdinWrite, stdin)
+		stdinWrite.Close()
+		close(delivered)
+	}()
+
+	var output []byte
+	var outputBytes int64
+	if opts.hash && opts.post == "" && opts.outputFile == "" && !opts.outputDir {
+		hasher := sha256.New()
+		var pipe io.ReadCloser
+		pipe, err = cmd.StdoutPipe()
+		if err != nil {
+			goto errHandle
+		}
+		err = startCommand(cmd)
+		if err != nil {
+			goto errHandle
+		}
+
+		var hashWriter io.Writer = hasher
+		if activity != nil {
+			hashWriter = io.MultiWriter(hasher, activity)
+		}
+		hashReader := io.TeeReader(pipe, hashWriter)
+
+		if outputBytes, err = io.Copy(io.Discard, hashReader); err == nil {
+			err = cmd.Wait()
+		}
+		output = []byte(hex.EncodeToString(hasher.Sum(nil)))
+	} else {
+		var stdout bytes.Buffer
+		cmd.Stdout = &stdout
+		if activity != nil {
+			cmd.Stdout = io.MultiWriter(&stdout, activity)
+		}
+		if err = startCommand(cmd); err == nil {
+			err = cmd.Wait()
+		}
+		output = stdout.Bytes()
+		outputBytes = int64(len(output))
+	}
+
+errHandle:
+	executionTime := time.Since(start)
+	teardownStart := time.Now()
+	Log.Debugf("%s: %s exited after %v (err: %v)", inputFile, programPath, executionTime, err)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		if opts.cpuLimit > 0 && exceededCPULimit(exitErr.ProcessState) {
+			return execResult{time: executionTime, cpuLimitExceeded: true}, context.DeadlineExceeded
+		}
+		if opts.okCodes[exitErr.ExitCode()] {
+			err = nil
+		} else {
+			err = &exitCodeError{code: exitErr.ExitCode(), state: exitErr.ProcessState}
+		}
+	}
+	if err != nil {
+		if atomic.LoadInt32(&idleExceeded) == 1 {
+			return execResult{time: executionTime, idle: true}, context.DeadlineExceeded
+		}
+		// Check if it was a timeout
+		if ctx.Err() == context.DeadlineExceeded {
+			consumed := inputConsumed(stdinRead, delivered)
+			result := execResult{time: executionTime, hangAfterRead: consumed && outputBytes == 0}
+			if reads != nil && consumed {
+				result.waitingForInput = reads.readingPastEOF()
+				result.inputRead = !result.waitingForInput
+			}
+			return result, context.DeadlineExceeded
+		}
+		if _, ok := err.(*exitCodeError); ok {
+			return execResult{time: executionTime}, err
+		}
+		return execResult{time: executionTime}, fmt.Errorf("program execution failed: %v", err)
+	}
+
+	if opts.outputFile != "" {
+		// What the program printed to stdout is ignored, only the output file counts
+		output, err = os.ReadFile(filepath.Join(cmd.Dir, opts.outputFile))
+		outputBytes = int64(len(output))
+		if os.IsNotExist(err) {
+			return execResult{time: executionTime}, fmt.Errorf("program didn't write its output file %s", opts.outputFile)
+		} else if err != nil {
+			return execResult{time: executionTime}, fmt.Errorf("failed to read output file %s: %v", opts.outputFile, err)
+		}
+	} else if opts.outputDir {
+		// What the program printed to stdout is ignored, only the files it wrote count
+		var tree string
+		if tree, err = readTree(cmd.Dir); err != nil {
+			return execResult{time: executionTime}, fmt.Errorf("failed to read output directory: %v", err)
+		}
+		output, outputBytes = []byte(tree), int64(len(tree))
+	}
+	if opts.post != "" {
+		postOutput, err := pipeThrough(opts.post, string(output), opts.timeout, "postprocessing command")
+		if err != nil {
+			return execResult{time: executionTime}, err
+		}
+		output = []byte(postOutput)
+	}
+	if opts.hash && (opts.post != "" || opts.outputFile != "") {
+		sum := sha256.Sum256(output)
+		output = []byte(hex.EncodeToString(sum[:]))
+	}
+
+	Log.Debugf("%s: setup took %v and teardown %v, not counted in the execution time", inputFile, setupTime, time.Since(teardownStart))
+
+	result := execResult{input: inputContent, output: string(output), time: executionTime, stderr: stderr.String(), outputSize: outputBytes}
+	if perfFile != "" {
+		stats, err := os.ReadFile(perfFile)
+		if err != nil {
+			Log.Warnf("%s: reading perf stat output: %v", inputFile, err)
+		}
+		result.perfStats = strings.TrimSpace(string(stats))
+	}
+	return result, nil
+}
+
+// overheadRuns is how many times -overhead runs the no-op program
+const overheadRuns = 5
+
+// measureOverhead estimates the cost of starting a process and waiting for it, which
+// dominates the time of tiny tests, as the median time of a few runs of a no-op command
+func measureOverhead(command []string, dir string) (time.Duration, error) {
+	times := make([]time.Duration, overheadRuns)
+	for i := range times {
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Dir = dir
+		cmd.Stdin = strings.NewReader("")
+		start := time.Now()
+		if _, err := cmd.Output(); err != nil {
+			return 0, fmt.Errorf("%s: %v", strings.Join(command, " "), err)
+		}
+		times[i] = time.Since(start)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2], nil
+}
+
+// adjustedTime subtracts the startup overhead from an execution time, never going below zero
+func adjustedTime(executionTime, overhead time.Duration) time.Duration {
+	if executionTime < overhead {
+		return 0
+	}
+	return executionTime - overhead
+}
+
+// resolveProgramPath adapts the program path to the host platform. On Windows,
+// ".exe" is appended when only the executable with that suffix exists, and a bare
+// file name in the current directory is made explicit since exec no longer
+// resolves programs relative to the current directory.
+func resolveProgramPath(programPath string) string {
+	if runtime.GOOS != "windows" {
+		return programPath
+	}
+	return windowsProgramPath(programPath)
+}
+
+// windowsProgramPath is resolveProgramPath on Windows, split out so it can be tested anywhere
+func windowsProgramPath(programPath string) string {
+	programPath = filepath.FromSlash(programPath)
+	if _, err := os.Stat(programPath); os.IsNotExist(err) && !strings.EqualFold(filepath.Ext(programPath), ".exe") {
+		if _, err := os.Stat(programPath + ".exe"); err == nil {
+			programPath += ".exe"
+		}
+	}
+	if !strings.ContainsAny(programPath, `\/:`) {
+		if _, err := os.Stat(programPath); err == nil {
+			programPath = "." + string(filepath.Separator) + programPath
+		}
+	}
+	return programPath
+}
+
+// interpreters maps script extensions to the interpreter that runs them when no -interpreter is given
+var interpreters = map[string][]string{
+	".py":  {"python3"},
+	".js":  {"node"},
+	".mjs": {"node"},
+	".ts":  {"ts-node"},
+	".rb":  {"ruby"},
+	".pl":  {"perl"},
+	".php": {"php"},
+	".lua": {"lua"},
+	".sh":  {"sh"},
+	".jar": {"java", "-jar"},
+}
+
+// detectInterpreter picks the interpreter for a program from its extension, or returns
+// nil for programs that run natively
+func detectInterpreter(programPath string) []string {
+	return interpreters[strings.ToLower(filepath.Ext(programPath))]
+}
+
+// checkProgram verifies up front that the program (or its interpreter) can be run,
+// so that a bad path is reported once instead of failing every test
+func checkProgram(programPath string, interpreter []string) error {
+	if len(interpreter) > 0 {
+		if _, err := exec.LookPath(interpreter[0]); err != nil {
+			return fmt.Errorf("interpreter %s not found: %v", interpreter[0], err)
+		}
+	} else if !strings.ContainsRune(programPath, '/') && !strings.ContainsRune(programPath, filepath.Separator) {
+		// A bare name is looked up in PATH, like a shell would
+		if _, err := exec.LookPath(programPath); err != nil {
+			return fmt.Errorf("%s not found in PATH (use ./%s for a program in the current directory)", programPath, programPath)
+		}
+		return nil
+	}
+
+	info, err := os.Stat(programPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s does not exist", programPath)
+	} else if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", programPath)
+	}
+	if len(interpreter) == 0 && runtime.GOOS != "windows" && info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("%s is not executable (use chmod +x, or -interpreter for scripts)", programPath)
+	}
+	return nil
+}
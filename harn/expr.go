@@ -0,0 +1,238 @@
+package harn
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// evalExpectedExpressions turns an expected file written as expressions into the
+// expected output. Each non-empty line is an arithmetic expression whose value
+// becomes one output line; $1, $2, ... refer to the whitespace-separated tokens
+// of the input and $# to the number of tokens.
+func evalExpectedExpressions(expressions, input string) (string, error) {
+	inputTokens := strings.Fields(input)
+	var lines []string
+	for i, line := range strings.Split(expressions, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		value, err := evalExpression(line, inputTokens)
+		if err != nil {
+			return "", fmt.Errorf("line %d: %v", i+1, err)
+		}
+		lines = append(lines, value.String())
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// exprValue is an integer unless a float operand or operation was involved
+type exprValue struct {
+	isFloat bool
+	i       int64
+	f       float64
+}
+
+func (v exprValue) float() float64 {
+	if v.isFloat {
+		return v.f
+	}
+	return float64(v.i)
+}
+
+func (v exprValue) String() string {
+	if v.isFloat {
+		return strconv.FormatFloat(v.f, 'f', -1, 64)
+	}
+	return strconv.FormatInt(v.i, 10)
+}
+
+// parseNumber reads an integer or float literal
+func parseNumber(text string) (exprValue, error) {
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return exprValue{i: i}, nil
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return exprValue{}, fmt.Errorf("invalid number %q", text)
+	}
+	return exprValue{isFloat: true, f: f}, nil
+}
+
+// exprParser is a recursive descent parser for the grammar:
+//
+//	expr   = term { ("+" | "-") term }
+//	term   = unary { ("*" | "/" | "%") unary }
+//	unary  = "-" unary | "+" unary | primary
+//	primary = number | "$" index | "$#" | "(" expr ")"
+//
+// Integer division truncates like in C, as in most contest languages.
+type exprParser struct {
+	src   string
+	pos   int
+	input []string
+}
+
+// evalExpression evaluates a single expression over the input tokens
+func evalExpression(src string, input []string) (exprValue, error) {
+	p := &exprParser{src: src, input: input}
+	value, err := p.expr()
+	if err != nil {
+		return exprValue{}, err
+	}
+	if p.skipSpace(); p.pos < len(p.src) {
+		return exprValue{}, fmt.Errorf("unexpected %q at column %d", p.src[p.pos], p.pos+1)
+	}
+	return value, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space character, or 0 at the end of the expression
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) expr() (exprValue, error) {
+	left, err := p.term()
+	for err == nil {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			break
+		}
+		p.pos++
+		var right exprValue
+		if right, err = p.term(); err == nil {
+			left, err = applyOp(op, left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) term() (exprValue, error) {
+	left, err := p.unary()
+	for err == nil {
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			break
+		}
+		p.pos++
+		var right exprValue
+		if right, err = p.unary(); err == nil {
+			left, err = applyOp(op, left, right)
+		}
+	}
+	return left, err
+}
+
+func (p *exprParser) unary() (exprValue, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		value, err := p.unary()
+		if err != nil {
+			return value, err
+		}
+		return applyOp('-', exprValue{}, value)
+	case '+':
+		p.pos++
+		return p.unary()
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (exprValue, error) {
+	switch c := p.peek(); {
+	case c == '(':
+		p.pos++
+		value, err := p.expr()
+		if err != nil {
+			return value, err
+		}
+		if p.peek() != ')' {
+			return value, fmt.Errorf("missing ) at column %d", p.pos+1)
+		}
+		p.pos++
+		return value, nil
+	case c == '$':
+		p.pos++
+		if p.pos < len(p.src) && p.src[p.pos] == '#' {
+			p.pos++
+			return exprValue{i: int64(len(p.input))}, nil
+		}
+		start := p.pos
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+		}
+		index, err := strconv.Atoi(p.src[start:p.pos])
+		if err != nil || index < 1 || index > len(p.input) {
+			return exprValue{}, fmt.Errorf("invalid input reference $%s (the input has %d tokens)", p.src[start:p.pos], len(p.input))
+		}
+		return parseNumber(p.input[index-1])
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.src) && strings.IndexByte("0123456789.eE", p.src[p.pos]) >= 0 {
+			// Allow a sign right after an exponent, as in 1e-9
+			if (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') && p.pos+1 < len(p.src) && (p.src[p.pos+1] == '-' || p.src[p.pos+1] == '+') {
+				p.pos++
+			}
+			p.pos++
+		}
+		return parseNumber(p.src[start:p.pos])
+	case c == 0:
+		return exprValue{}, fmt.Errorf("unexpected end of expression")
+	default:
+		return exprValue{}, fmt.Errorf("unexpected %q at column %d", c, p.pos+1)
+	}
+}
+
+// applyOp applies a binary operator, staying in integer arithmetic when both operands are integers
+func applyOp(op byte, a, b exprValue) (exprValue, error) {
+	if !a.isFloat && !b.isFloat {
+		switch op {
+		case '+':
+			return exprValue{i: a.i + b.i}, nil
+		case '-':
+			return exprValue{i: a.i - b.i}, nil
+		case '*':
+			return exprValue{i: a.i * b.i}, nil
+		case '/', '%':
+			if b.i == 0 {
+				return exprValue{}, fmt.Errorf("division by zero")
+			}
+			if op == '/' {
+				return exprValue{i: a.i / b.i}, nil
+			}
+			return exprValue{i: a.i % b.i}, nil
+		}
+	}
+	x, y := a.float(), b.float()
+	switch op {
+	case '+':
+		return exprValue{isFloat: true, f: x + y}, nil
+	case '-':
+		return exprValue{isFloat: true, f: x - y}, nil
+	case '*':
+		return exprValue{isFloat: true, f: x * y}, nil
+	case '/':
+		if y == 0 {
+			return exprValue{}, fmt.Errorf("division by zero")
+		}
+		return exprValue{isFloat: true, f: x / y}, nil
+	default:
+		if y == 0 {
+			return exprValue{}, fmt.Errorf("division by zero")
+		}
+		return exprValue{isFloat: true, f: math.Mod(x, y)}, nil
+	}
+}
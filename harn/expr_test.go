@@ -0,0 +1,32 @@
+package harn
+
+import "testing"
+
+func TestEvalExpectedExpressions(t *testing.T) {
+	tests := []struct {
+		expressions, input, want string
+	}{
+		{"$1 + $2", "1 2", "3"},
+		{"$1 * $2\n$1 - $2", "6 4", "24\n2"},
+		{"$#", "5 6 7", "3"},
+		{"7 / 2\n-7 / 2\n7 % 3", "", "3\n-3\n1"},
+		{"$1 / 2", "5.0", "2.5"},
+		{"-(1 + 2) * 3", "", "-9"},
+		{"1e-3 * 1000", "", "1"},
+		{"\n$1\n\n", "42", "42"},
+	}
+	for _, tt := range tests {
+		got, err := evalExpectedExpressions(tt.expressions, tt.input)
+		if err != nil || got != tt.want {
+			t.Errorf("evalExpectedExpressions(%q, %q) = %q, %v, want %q", tt.expressions, tt.input, got, err, tt.want)
+		}
+	}
+}
+
+func TestEvalExpectedExpressionsErrors(t *testing.T) {
+	for _, expressions := range []string{"$3", "1 / 0", "(1 + 2", "1 +", "2 3", "$1 + x"} {
+		if got, err := evalExpectedExpressions(expressions, "1 2"); err == nil {
+			t.Errorf("evalExpectedExpressions(%q) = %q, want an error", expressions, got)
+		}
+	}
+}
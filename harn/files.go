@@ -0,0 +1,540 @@
+package harn
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// testBaseName strips the input extension (.in, or .cmd for generated inputs) from a test file
+func testBaseName(inputFile string) string {
+	if strings.HasSuffix(inputFile, ".cmd") {
+		return strings.TrimSuffix(inputFile, ".cmd")
+	}
+	return strings.TrimSuffix(inputFile, ".in")
+}
+
+// globTests expands the test pattern. Like in a shell, wildcards don't match hidden
+// files and directories (starting with a dot) unless includeHidden is set; a dot
+// written in the pattern itself, as in ".tests/*.in", always matches.
+func globTests(pattern string, includeHidden bool) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil || includeHidden {
+		return matches, err
+	}
+	// Glob cleans its results, so the pattern is cleaned the same way to line up their components
+	patternParts := strings.Split(filepath.Clean(pattern), string(filepath.Separator))
+	visible := matches[:0]
+	for _, match := range matches {
+		if !isHiddenMatch(patternParts, strings.Split(match, string(filepath.Separator))) {
+			visible = append(visible, match)
+		}
+	}
+	return visible, nil
+}
+
+// isHiddenMatch reports whether a wildcard in the pattern matched a dot-prefixed path component
+func isHiddenMatch(patternParts, matchParts []string) bool {
+	if len(patternParts) != len(matchParts) {
+		return false
+	}
+	for i, part := range matchParts {
+		if strings.HasPrefix(part, ".") && !strings.HasPrefix(patternParts[i], ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// renderOutputTemplate locates the expected file of a test from a template such as
+// "golden/{name}.{ext}". {name} is the test's file name without its extension,
+// {dir} the directory of the input and {ext} the expected extension (out or hash).
+func renderOutputTemplate(template, testBase, expectedExt string) string {
+	return filepath.FromSlash(strings.NewReplacer(
+		"{name}", filepath.Base(testBase),
+		"{dir}", filepath.ToSlash(filepath.Dir(testBase)),
+		"{ext}", strings.TrimPrefix(expectedExt, "."),
+	).Replace(template))
+}
+
+// inputForExpected finds the input of an expected file such as tests/3.out. The
+// usual tests/3.in or tests/3.cmd is preferred, otherwise the only other file
+// sharing the base name is used, whatever its extension.
+func inputForExpected(expectedFile, expectedExt string) (string, error) {
+	base := strings.TrimSuffix(expectedFile, expectedExt)
+	for _, ext := range []string{".in", ".cmd"} {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext, nil
+		}
+	}
+
+	matches, err := filepath.Glob(base + ".*")
+	if err != nil {
+		return "", err
+	}
+	var candidates []string
+	for _, match := range matches {
+		ext := strings.TrimPrefix(match, base)
+		// Skip the expected file itself, its numbered alternates and other per-test files
+		if strings.HasPrefix(ext, ".out") || strings.HasPrefix(ext, ".hash") || ext == ".weight" {
+			continue
+		}
+		candidates = append(candidates, match)
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("no input file named %s.*", base)
+	case 1:
+		return candidates[0], nil
+	default:
+		return "", fmt.Errorf("ambiguous input, found %s", strings.Join(candidates, ", "))
+	}
+}
+
+// Descriptions come from a .desc file, or with -inline-desc from a first input line
+// starting with descriptionMarker, and are shortened to maxDescriptionLen characters
+const (
+	descriptionMarker = "#:"
+	maxDescriptionLen = 40
+)
+
+// readDescription returns the human-readable description of a test, if it has one.
+// Without a .desc file and with inline set, the description is read from a first
+// input line starting with descriptionMarker, and fromInput reports that the line
+// is there and has to be removed before the program reads the input.
+func readDescription(testBase, inputFile string, inline bool) (description string, fromInput bool) {
+	description, err := readFile(testBase + ".desc")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Log.Warnf("Failed to read description %s.desc: %v", testBase, err)
+		}
+		description = ""
+		if inline && !strings.HasSuffix(inputFile, ".cmd") {
+			prefix := readPrefix(inputFile, 256)
+			description, fromInput = parseDescription(prefix), strings.HasPrefix(prefix, descriptionMarker)
+		}
+	}
+	description = strings.Join(strings.Fields(description), " ")
+	if runes := []rune(description); len(runes) > maxDescriptionLen {
+		description = string(runes[:maxDescriptionLen-3]) + "..."
+	}
+	return description, fromInput
+}
+
+// readPrefix reads up to n bytes from the start of a file, ignoring errors
+func readPrefix(filename string, n int) string {
+	file, err := os.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	prefix := make([]byte, n)
+	read, _ := io.ReadFull(file, prefix)
+	return string(prefix[:read])
+}
+
+// parseDescription extracts the description comment on the first line of an input, if any
+func parseDescription(input string) string {
+	firstLine := strings.SplitN(input, "\n", 2)[0]
+	if !strings.HasPrefix(firstLine, descriptionMarker) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(firstLine, descriptionMarker))
+}
+
+// stripDescription removes the description comment from an input before the program reads it
+func stripDescription(input string) string {
+	if !strings.HasPrefix(input, descriptionMarker) {
+		return input
+	}
+	if i := strings.IndexByte(input, '\n'); i >= 0 {
+		return input[i+1:]
+	}
+	return ""
+}
+
+// sampleFiles picks n files at random using the given seed, keeping their original order
+func sampleFiles(files []string, n int, seed int64) []string {
+	rng := rand.New(rand.NewSource(seed))
+	picked := rng.Perm(len(files))[:n]
+	sort.Ints(picked)
+
+	sampled := make([]string, 0, n)
+	for _, i := range picked {
+		sampled = append(sampled, files[i])
+	}
+	return sampled
+}
+
+// readWeight reads the relative time budget of a test from its .weight file, tests without one weigh 1
+func readWeight(weightFile string) (float64, error) {
+	content, err := os.ReadFile(weightFile)
+	if os.IsNotExist(err) {
+		return 1, nil
+	} else if err != nil {
+		return 1, err
+	}
+	weight, err := strconv.ParseFloat(strings.TrimSpace(string(content)), 64)
+	if err != nil || weight <= 0 {
+		return 1, fmt.Errorf("invalid weight %q in %s", strings.TrimSpace(string(content)), weightFile)
+	}
+	return weight, nil
+}
+
+// hashManifest maps tests to their expected output hashes in a single file, in the
+// "<hash>  <name>" format of sha256sum. Entries name either the test's input or its
+// expected output file, so `sha256sum tests/*.out` makes a valid manifest.
+type hashManifest struct {
+	filename string
+	hashes   map[string]string
+	changed  bool
+}
+
+// readHashManifest loads a hash manifest, a missing file is an empty manifest
+func readHashManifest(filename string) (*hashManifest, error) {
+	m := &hashManifest{filename: filename, hashes: make(map[string]string)}
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for i, line := range strings.Split(normalizeEOL(string(data)), "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, name, found := strings.Cut(line, " ")
+		// sha256sum marks files hashed in binary mode with a * before the name
+		name = strings.TrimPrefix(strings.TrimLeft(name, " "), "*")
+		if !found || name == "" {
+			return nil, fmt.Errorf("line %d: expected \"<hash>  <test name>\", got %q", i+1, line)
+		}
+		m.hashes[filepath.Clean(name)] = strings.ToLower(hash)
+	}
+	return m, nil
+}
+
+// key returns the entry of a test, by its input or else by its .out file, or the input for a new entry
+func (m *hashManifest) key(inputFile, testBase string) string {
+	for _, name := range []string{inputFile, testBase + ".out"} {
+		if _, ok := m.hashes[filepath.Clean(name)]; ok {
+			return filepath.Clean(name)
+		}
+	}
+	return filepath.Clean(inputFile)
+}
+
+// lookup returns the expected hash of a test, if the manifest has one
+func (m *hashManifest) lookup(inputFile, testBase string) (string, bool) {
+	hash, ok := m.hashes[m.key(inputFile, testBase)]
+	return hash, ok
+}
+
+// set records the expected hash of a test, to be saved by write
+func (m *hashManifest) set(inputFile, testBase, hash string) {
+	m.hashes[m.key(inputFile, testBase)] = hash
+	m.changed = true
+}
+
+// write saves the manifest sorted by name, if any entry was set
+func (m *hashManifest) write() error {
+	if !m.changed {
+		return nil
+	}
+	names := make([]string, 0, len(m.hashes))
+	for name := range m.hashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var content strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&content, "%s  %s\n", m.hashes[name], filepath.ToSlash(name))
+	}
+	return writeFile(m.filename, content.String())
+}
+
+// expectedAlternates returns the expected output file followed by its numbered
+// alternates (e.g. test1.out.2, test1.out.3), any of which is an acceptable output
+func expectedAlternates(outputFile string) []string {
+	matches, _ := filepath.Glob(outputFile + ".*")
+	var numbers []int
+	for _, match := range matches {
+		if n, err := strconv.Atoi(strings.TrimPrefix(match, outputFile+".")); err == nil && n > 0 {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+
+	var files []string
+	if _, err := os.Stat(outputFile); err == nil || len(numbers) == 0 {
+		files = append(files, outputFile)
+	}
+	for _, n := range numbers {
+		files = append(files, fmt.Sprintf("%s.%d", outputFile, n))
+	}
+	return files
+}
+
+// platformExpected picks the expected file for the current platform: test1.out.linux-amd64
+// or test1.out.linux when one of them exists, and test1.out otherwise. Under perPlatform
+// it is always the OS-suffixed file, for -g to write.
+func platformExpected(outputFile string, perPlatform bool) string {
+	if perPlatform {
+		return outputFile + "." + runtime.GOOS
+	}
+	for _, platformFile := range []string{outputFile + "." + runtime.GOOS + "-" + runtime.GOARCH, outputFile + "." + runtime.GOOS} {
+		if expectedExists(platformFile) {
+			return platformFile
+		}
+	}
+	return outputFile
+}
+
+// detectExpectedFormat tells whether a test is compared by hash, going by which of its
+// expected files exists, so that a suite can mix .out and .hash files. The hash format
+// -h asks for decides when neither exists or both do; ambiguous reports the latter.
+func detectExpectedFormat(testBase string, hash bool) (useHash, ambiguous bool) {
+	outExists := expectedExists(platformExpected(testBase+".out", false))
+	hashExists := expectedExists(platformExpected(testBase+".hash", false))
+	switch {
+	case outExists && hashExists:
+		return hash, true
+	case outExists || hashExists:
+		return hashExists, false
+	}
+	return hash, false
+}
+
+// snapshotFile names the recorded output of a test in a -self-baseline directory. It mirrors
+// the test's path so that tests in different directories don't collide, with ".." spelled
+// "__" to stay inside the directory.
+func snapshotFile(dir, testBase, expectedExt string) string {
+	testBase = filepath.Clean(testBase)
+	parts := strings.Split(filepath.ToSlash(strings.TrimPrefix(testBase, filepath.VolumeName(testBase))), "/")
+	for i, part := range parts {
+		if part == ".." {
+			parts[i] = "__"
+		}
+	}
+	return filepath.Join(dir, filepath.Join(parts...)+expectedExt)
+}
+
+// writeFile writes content to a file, creating its directory if needed
+func writeFile(filename, content string) error {
+	// Expected files placed by -out-template may live in a directory that doesn't exist yet
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(content)
+	return err
+}
+
+// expectedExists reports whether a test has an expected file, or at least one numbered alternate
+func expectedExists(outputFile string) bool {
+	for _, expectedFile := range expectedAlternates(outputFile) {
+		if _, err := os.Stat(expectedFile); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// expectedFileError explains why an expected file couldn't be read, telling a missing
+// file, a directory in its place and missing permissions apart from other read errors
+func expectedFileError(expectedFile string, err error) error {
+	if os.IsNotExist(err) {
+		return fmt.Errorf("expected output %s does not exist (use -g to generate it)", expectedFile)
+	} else if os.IsPermission(err) {
+		return fmt.Errorf("expected output %s is not readable: permission denied", expectedFile)
+	}
+	if info, statErr := os.Stat(expectedFile); statErr == nil && info.IsDir() {
+		return fmt.Errorf("expected output %s is a directory, not a file", expectedFile)
+	}
+	return fmt.Errorf("reading expected output %s: %v", expectedFile, err)
+}
+
+// readFile reads the entire content of a file and returns it as a string
+func readFile(filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var content strings.Builder
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		content.WriteString(scanner.Text())
+		content.WriteString("\n")
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(content.String(), "\n"), nil
+}
+
+// treeHeader starts every file of a serialized directory tree, as "=== path (N bytes)"
+const treeHeader = "=== "
+
+// readTree serializes a directory tree for -outdir-mode: every regular file in path order,
+// each as a header line naming it and its size followed by its content and a line break.
+// Empty directories leave no trace.
+func readTree(dir string) (string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+	var tree strings.Builder
+	err = filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&tree, "%s%s (%d bytes)\n%s\n", treeHeader, filepath.ToSlash(rel), len(content), content)
+		return nil
+	})
+	return tree.String(), err
+}
+
+// treeFile is a file of a serialized directory tree
+type treeFile struct {
+	path, content string
+}
+
+// parseTree splits a tree serialized by readTree back into its files
+func parseTree(tree string) ([]treeFile, error) {
+	var files []treeFile
+	for tree != "" {
+		header, rest, ok := strings.Cut(tree, "\n")
+		open := strings.LastIndex(header, " (")
+		if !ok || !strings.HasPrefix(header, treeHeader) || open < 0 || !strings.HasSuffix(header, " bytes)") {
+			return nil, fmt.Errorf("malformed file header %q", header)
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(header[open+2:], " bytes)"))
+		if err != nil || size < 0 || size >= len(rest) || rest[size] != '\n' {
+			return nil, fmt.Errorf("malformed file header %q", header)
+		}
+		files = append(files, treeFile{path: header[len(treeHeader):open], content: rest[:size]})
+		tree = rest[size+1:]
+	}
+	return files, nil
+}
+
+// writeTree replaces a directory with the files of a serialized tree, for -g and -u under -outdir-mode
+func writeTree(dir, tree string) error {
+	files, err := parseTree(tree)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if path := filepath.FromSlash(file.path); filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("refusing to write %s outside of %s", file.path, dir)
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := writeFile(filepath.Join(dir, filepath.FromSlash(file.path)), file.content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// treeComparison lists how an actual output tree differs from the golden one
+type treeComparison struct {
+	added, removed []string
+	changed        []treeFileChange
+}
+
+// treeFileChange is a file whose content differs between two trees
+type treeFileChange struct {
+	path, expected, actual string
+}
+
+// compareTrees compares two trees serialized by readTree file by file
+func compareTrees(expected, actual string) (treeComparison, error) {
+	var comparison treeComparison
+	expectedFiles, err := parseTree(expected)
+	if err != nil {
+		return comparison, err
+	}
+	actualFiles, err := parseTree(actual)
+	if err != nil {
+		return comparison, err
+	}
+	expectedContent := make(map[string]string)
+	for _, file := range expectedFiles {
+		expectedContent[file.path] = file.content
+	}
+	for _, file := range actualFiles {
+		if content, ok := expectedContent[file.path]; !ok {
+			comparison.added = append(comparison.added, file.path)
+		} else if content != file.content {
+			comparison.changed = append(comparison.changed, treeFileChange{path: file.path, expected: content, actual: file.content})
+		}
+		delete(expectedContent, file.path)
+	}
+	for _, file := range expectedFiles {
+		if _, ok := expectedContent[file.path]; ok {
+			comparison.removed = append(comparison.removed, file.path)
+		}
+	}
+	return comparison, nil
+}
+
+// String summarizes the comparison, e.g. "added extra.txt; removed log.txt; changed a.txt and b/c.txt"
+func (c treeComparison) String() string {
+	var changed []string
+	for _, file := range c.changed {
+		changed = append(changed, file.path)
+	}
+	var changes []string
+	for _, kind := range []struct {
+		name  string
+		paths []string
+	}{{"added", c.added}, {"removed", c.removed}, {"changed", changed}} {
+		if len(kind.paths) > 0 {
+			changes = append(changes, kind.name+" "+joinNames(kind.paths))
+		}
+	}
+	return strings.Join(changes, "; ")
+}
+
+// joinNames lists names in prose, e.g. "a, b and c"
+func joinNames(names []string) string {
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
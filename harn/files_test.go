@@ -0,0 +1,314 @@
+package harn
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestReadDescription(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"comment.in": "#: large random graph\n5 6\n",
+		"desc.in":    "#: ignored\n1\n",
+		"desc.desc":  "from the desc file\n",
+		"long.desc":  strings.Repeat("word ", 20),
+		"plain.in":   "1 2\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		test      string
+		inline    bool
+		want      string
+		fromInput bool
+	}{
+		{"comment", true, "large random graph", true},
+		{"comment", false, "", false},
+		{"desc", true, "from the desc file", false},
+		{"long", false, strings.Repeat("word ", 7) + "wo...", false},
+		{"plain", true, "", false},
+	}
+	for _, tt := range tests {
+		base := filepath.Join(dir, tt.test)
+		if got, fromInput := readDescription(base, base+".in", tt.inline); got != tt.want || fromInput != tt.fromInput {
+			t.Errorf("readDescription(%s, %v) = %q, %v; want %q, %v", tt.test, tt.inline, got, fromInput, tt.want, tt.fromInput)
+		}
+	}
+}
+
+func TestStripDescription(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"#: graph\n5 6", "5 6"},
+		{"#: only a comment", ""},
+		{"5 6\n#: not first", "5 6\n#: not first"},
+		{"# a normal comment\n5", "# a normal comment\n5"},
+	}
+	for _, tt := range tests {
+		if got := stripDescription(tt.input); got != tt.want {
+			t.Errorf("stripDescription(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestRenderOutputTemplate(t *testing.T) {
+	tests := []struct {
+		template, testBase, ext, want string
+	}{
+		{"golden/{name}.{ext}", "tests/3", ".out", "golden/3.out"},
+		{"{dir}/expected/{name}.{ext}", "tests/sub/3", ".hash", "tests/sub/expected/3.hash"},
+		{"{name}.ans", "3", ".out", "3.ans"},
+	}
+	for _, tt := range tests {
+		if got := renderOutputTemplate(tt.template, filepath.FromSlash(tt.testBase), tt.ext); got != filepath.FromSlash(tt.want) {
+			t.Errorf("renderOutputTemplate(%q, %q) = %q, want %q", tt.template, tt.testBase, got, tt.want)
+		}
+	}
+}
+
+func TestExpectedFileError(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.out")
+	if _, err := readFile(missing); !strings.Contains(expectedFileError(missing, err).Error(), "does not exist") {
+		t.Errorf("missing file: got %v", expectedFileError(missing, err))
+	}
+	directory := filepath.Join(dir, "dir.out")
+	if err := os.Mkdir(directory, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readFile(directory); !strings.Contains(expectedFileError(directory, err).Error(), "is a directory") {
+		t.Errorf("directory: got %v", expectedFileError(directory, err))
+	}
+}
+
+func TestPlatformExpected(t *testing.T) {
+	dir := t.TempDir()
+	plain, other := filepath.Join(dir, "1.out"), filepath.Join(dir, "2.out")
+	osFile, archFile := plain+"."+runtime.GOOS, other+"."+runtime.GOOS+"-"+runtime.GOARCH
+	for _, file := range []string{plain, osFile, other, other + ".plan9", archFile} {
+		if err := os.WriteFile(file, []byte("1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		outputFile  string
+		perPlatform bool
+		want        string
+	}{
+		{plain, false, osFile},
+		{other, false, archFile},
+		{filepath.Join(dir, "3.out"), false, filepath.Join(dir, "3.out")},
+		{other, true, other + "." + runtime.GOOS},
+	}
+	for _, tt := range tests {
+		if got := platformExpected(tt.outputFile, tt.perPlatform); got != tt.want {
+			t.Errorf("platformExpected(%q, %v) = %q, want %q", tt.outputFile, tt.perPlatform, got, tt.want)
+		}
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	tests := map[string]string{
+		"tests/1":        "snap/tests/1.out",
+		"./1":            "snap/1.out",
+		"../other/1":     "snap/__/other/1.out",
+		"/abs/tests/big": "snap/abs/tests/big.out",
+	}
+	for testBase, want := range tests {
+		if got := filepath.ToSlash(snapshotFile("snap", filepath.FromSlash(testBase), ".out")); got != want {
+			t.Errorf("snapshotFile(%q) = %q, want %q", testBase, got, want)
+		}
+	}
+}
+
+func TestTree(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "1.outdir")
+	files := map[string]string{"a.txt": "1\n", "sub/b.txt": "no newline", "empty.txt": ""}
+	for name, content := range files {
+		if err := writeFile(filepath.Join(golden, filepath.FromSlash(name)), content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := readTree(golden)
+	if err != nil {
+		t.Fatalf("readTree() failed: %v", err)
+	}
+	copied := filepath.Join(t.TempDir(), "copy")
+	if err := writeTree(copied, tree); err != nil {
+		t.Fatalf("writeTree() failed: %v", err)
+	}
+	if copiedTree, err := readTree(copied); err != nil || copiedTree != tree {
+		t.Errorf("tree written by writeTree reads back as %q (%v), want %q", copiedTree, err, tree)
+	}
+
+	actual := strings.Replace(tree, "=== a.txt (2 bytes)\n1\n", "=== a.txt (2 bytes)\n2\n", 1)
+	actual = strings.Replace(actual, "=== empty.txt (0 bytes)\n\n", "", 1) + "=== z.txt (0 bytes)\n\n"
+	comparison, err := compareTrees(tree, actual)
+	if err != nil {
+		t.Fatalf("compareTrees() failed: %v", err)
+	}
+	if want := "added z.txt; removed empty.txt; changed a.txt"; comparison.String() != want {
+		t.Errorf("compareTrees() = %q, want %q", comparison.String(), want)
+	}
+	if len(comparison.changed) != 1 || comparison.changed[0].expected != "1\n" || comparison.changed[0].actual != "2\n" {
+		t.Errorf("compareTrees() changed = %+v, want a.txt from 1 to 2", comparison.changed)
+	}
+
+	if err := writeTree(copied, "=== ../escape.txt (0 bytes)\n\n"); err == nil {
+		t.Errorf("writeTree() wrote a file outside of its directory")
+	}
+}
+
+func TestHashManifest(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tests.sha256")
+	content := "# made by sha256sum\nAAAA  tests/1.out\nbbbb *tests/2.in\n"
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := readHashManifest(filename)
+	if err != nil {
+		t.Fatalf("readHashManifest() failed: %v", err)
+	}
+	for _, test := range []struct{ inputFile, want string }{{"tests/1.in", "aaaa"}, {"tests/2.in", "bbbb"}, {"tests/3.in", ""}} {
+		if got, _ := m.lookup(filepath.FromSlash(test.inputFile), filepath.FromSlash(strings.TrimSuffix(test.inputFile, ".in"))); got != test.want {
+			t.Errorf("lookup(%s) = %q, want %q", test.inputFile, got, test.want)
+		}
+	}
+
+	m.set(filepath.FromSlash("tests/1.in"), filepath.FromSlash("tests/1"), "cccc")
+	m.set(filepath.FromSlash("tests/3.in"), filepath.FromSlash("tests/3"), "dddd")
+	if err := m.write(); err != nil {
+		t.Fatalf("write() failed: %v", err)
+	}
+	written, _ := os.ReadFile(filename)
+	if want := "cccc  tests/1.out\nbbbb  tests/2.in\ndddd  tests/3.in\n"; string(written) != want {
+		t.Errorf("written manifest = %q, want %q", written, want)
+	}
+}
+
+func TestGlobTests(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"1.in", ".2.in", ".hidden/3.in", "tests/4.in"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		pattern       string
+		includeHidden bool
+		want          []string
+	}{
+		{"*.in", false, []string{"1.in"}},
+		{"*.in", true, []string{".2.in", "1.in"}},
+		{"*/*.in", false, []string{"tests/4.in"}},
+		{"*/*.in", true, []string{".hidden/3.in", "tests/4.in"}},
+		{".hidden/*.in", false, []string{".hidden/3.in"}},
+		{".*.in", false, []string{".2.in"}},
+	}
+	for _, tt := range tests {
+		got, err := globTests(filepath.Join(dir, tt.pattern), tt.includeHidden)
+		if err != nil {
+			t.Fatalf("globTests(%q) failed: %v", tt.pattern, err)
+		}
+		for i := range got {
+			got[i] = filepath.ToSlash(strings.TrimPrefix(got[i], dir+string(filepath.Separator)))
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("globTests(%q, %v) = %q, want %q", tt.pattern, tt.includeHidden, got, tt.want)
+		}
+	}
+}
+
+// TestGlobSeparators matches patterns the way Run does, after filepath.FromSlash. A
+// backslash separates directories on Windows, and escapes the next character elsewhere.
+func TestGlobSeparators(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tests", "sub", "1.in")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	backslashMatches := []string(nil)
+	if filepath.Separator == '\\' {
+		backslashMatches = []string{"tests/sub/1.in"}
+	}
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"tests/sub/*.in", []string{"tests/sub/1.in"}},
+		{"tests/*/*.in", []string{"tests/sub/1.in"}},
+		{`tests\sub\*.in`, backslashMatches},
+		{`tests/sub\*.in`, backslashMatches},
+	}
+	for _, tt := range tests {
+		got, err := globTests(filepath.FromSlash(dir+"/"+tt.pattern), false)
+		if err != nil {
+			t.Fatalf("globTests(%q) failed: %v", tt.pattern, err)
+		}
+		for i := range got {
+			got[i] = filepath.ToSlash(strings.TrimPrefix(got[i], dir+string(filepath.Separator)))
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("globTests(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestResolveProgramPath(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"sol.exe", "both", "both.exe", "script"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	sep := string(filepath.Separator)
+	tests := []struct {
+		program string
+		want    string
+	}{
+		// .exe is only added when the program doesn't exist as given
+		{"sol", "." + sep + "sol.exe"},
+		{"sol.exe", "." + sep + "sol.exe"},
+		{"both", "." + sep + "both"},
+		{"script", "." + sep + "script"},
+		{"./sol", "." + sep + "sol.exe"},
+		{dir + "/sol", filepath.Join(dir, "sol.exe")},
+		// Programs found on the PATH keep their bare name
+		{"missing", "missing"},
+		{"./missing", "." + sep + "missing"},
+	}
+	for _, tt := range tests {
+		if got := windowsProgramPath(tt.program); got != tt.want {
+			t.Errorf("windowsProgramPath(%q) = %q, want %q", tt.program, got, tt.want)
+		}
+		want := tt.program
+		if runtime.GOOS == "windows" {
+			want = tt.want
+		}
+		if got := resolveProgramPath(tt.program); got != want {
+			t.Errorf("resolveProgramPath(%q) = %q on %s, want %q", tt.program, got, runtime.GOOS, want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package harn
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readIOCounters reads the read syscall count and the bytes read so far of a process from /proc/<pid>/io
+func readIOCounters(pid int) (ioCounters, error) {
+	content, err := os.ReadFile(fmt.Sprintf("/proc/%d/io", pid))
+	if err != nil {
+		return ioCounters{}, err
+	}
+	var counters ioCounters
+	found := 0
+	for _, line := range strings.Split(string(content), "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch name {
+		case "syscr":
+			counters.reads = n
+			found++
+		case "rchar":
+			counters.bytes = n
+			found++
+		}
+	}
+	if found != 2 {
+		return ioCounters{}, fmt.Errorf("/proc/%d/io has no syscr and rchar counts", pid)
+	}
+	return counters, nil
+}
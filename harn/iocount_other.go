@@ -0,0 +1,10 @@
+//go:build !linux
+
+package harn
+
+import "errors"
+
+// readIOCounters is only implemented on Linux
+func readIOCounters(pid int) (ioCounters, error) {
+	return ioCounters{}, errors.New("reading I/O counters is not supported on this platform")
+}
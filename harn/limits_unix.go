@@ -0,0 +1,24 @@
+//go:build !windows
+
+package harn
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// limitsSupported reports whether -cpulimit and -m can be enforced on this platform
+const limitsSupported = true
+
+// withLimits wraps a command line so it runs with a soft RLIMIT_CPU and RLIMIT_AS.
+// The shell execs the program, which inherits the limits; zero means no limit.
+func withLimits(argv []string, cpuLimit time.Duration, memLimit int64) []string {
+	return append([]string{"sh", "-c", ulimitCommand(cpuLimit, memLimit) + ` && exec "$@"`, "sh"}, argv...)
+}
+
+// exceededCPULimit reports whether a process was killed for using up its CPU time
+func exceededCPULimit(state *os.ProcessState) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGXCPU
+}
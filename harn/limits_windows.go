@@ -0,0 +1,19 @@
+package harn
+
+import (
+	"os"
+	"time"
+)
+
+// limitsSupported reports whether -cpulimit and -m can be enforced on this platform
+const limitsSupported = false
+
+// withLimits is only implemented on Unix
+func withLimits(argv []string, cpuLimit time.Duration, memLimit int64) []string {
+	return argv
+}
+
+// exceededCPULimit is only implemented on Unix
+func exceededCPULimit(state *os.ProcessState) bool {
+	return false
+}
@@ -0,0 +1,99 @@
+package harn
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// LogLevel orders log messages by severity, lower values are more verbose
+type LogLevel int
+
+const (
+	levelDebug LogLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = map[string]LogLevel{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+func (l LogLevel) String() string {
+	for name, level := range levelNames {
+		if level == l {
+			return strings.ToUpper(name)
+		}
+	}
+	return "UNKNOWN"
+}
+
+// Logger is a minimal leveled logger for diagnostics about harn itself,
+// kept apart from the human-readable test results printed on stdout
+type Logger struct {
+	level LogLevel
+	out   *log.Logger
+	file  *os.File
+}
+
+// Log is the process-wide logger, it writes warnings and errors to stderr until configured
+var Log = &Logger{level: levelWarn, out: log.New(os.Stderr, "", log.LstdFlags)}
+
+// ParseLogLevel converts a level name such as "info" into a LogLevel
+func ParseLogLevel(name string) (LogLevel, error) {
+	level, ok := levelNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", name)
+	}
+	return level, nil
+}
+
+// Configure sets the minimum level and, if logFile is not empty, appends log output to that file
+func (l *Logger) Configure(level LogLevel, logFile string) error {
+	l.level = level
+	if logFile == "" {
+		return nil
+	}
+	file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	l.out = log.New(file, "", log.LstdFlags|log.Lmicroseconds)
+	return nil
+}
+
+// Close flushes and closes the log file, if one is open
+func (l *Logger) Close() {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+func (l *Logger) logf(level LogLevel, format string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+	l.out.Printf("[%s] %s", level, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) { l.logf(levelDebug, format, args...) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.logf(levelInfo, format, args...) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.logf(levelWarn, format, args...) }
+func (l *Logger) Errorf(format string, args ...interface{}) { l.logf(levelError, format, args...) }
+
+// Fatalf logs an error and exits, the message is always shown on stderr even when logging to a file
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.logf(levelError, format, args...)
+	if l.file != nil {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+	l.Close()
+	os.Exit(1)
+}
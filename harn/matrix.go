@@ -0,0 +1,91 @@
+package harn
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// matrixRun is how the suite did under one -matrix argument set
+type matrixRun struct {
+	label   string
+	results Results
+}
+
+// runMatrix runs the whole suite once for each argument set of cfg.Matrix, passed to the
+// program after cfg.ProgramArgs, then summarizes which sets pass every test. The results
+// of all runs are returned together, each test named after its argument set.
+func (r *Runner) runMatrix(cfg Config) (Results, error) {
+	out := r.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	if cfg.Generate || cfg.Update || cfg.Repl || cfg.ShowConfig || cfg.Pattern == "-" {
+		return Results{}, errors.New("-matrix cannot be combined with -g, -u, -repl, -show-config or the \"-\" pattern")
+	}
+	if cfg.JSONFile != "" || cfg.ResumeFile != "" || cfg.RerunFailed || cfg.NewFailuresOnly {
+		return Results{}, errors.New("-matrix cannot be combined with -json, -resume, -rerun-failed or -new-failures-only, which would mix up the argument sets")
+	}
+	var runs []matrixRun
+	var all Results
+	for i, args := range cfg.Matrix {
+		run := matrixRun{label: strings.Join(args, " ")}
+		fmt.Fprintf(out, "%s=== Argument set %d of %d: %s%s\n", Yellow, i+1, len(cfg.Matrix), run.label, Reset)
+		variant := cfg
+		// The failing tests of one argument set say nothing about the next
+		variant.Matrix, variant.StateFile = nil, ""
+		variant.ProgramArgs = append(append([]string{}, cfg.ProgramArgs...), args...)
+		results, err := r.Run(variant)
+		if err != nil {
+			return Results{}, fmt.Errorf("argument set %q: %v", run.label, err)
+		}
+		run.results = results
+		runs = append(runs, run)
+		for _, test := range results.Tests {
+			test.Name = fmt.Sprintf("%s [%s]", test.Name, run.label)
+			all.Tests = append(all.Tests, test)
+		}
+		all.TotalTime += results.TotalTime
+		all.NotRun += results.NotRun
+		all.Failed = all.Failed || results.Failed
+		fmt.Fprintln(out)
+	}
+	printMatrixSummary(out, runs)
+	return all, nil
+}
+
+// passesAll reports whether every test of a run passed, none of them left out
+func passesAll(results Results) bool {
+	return len(results.Tests) > 0 && countFailures(results.Tests) == 0 && results.NotRun == 0
+}
+
+// printMatrixSummary shows one line per -matrix argument set, and the sets that pass every test
+func printMatrixSummary(out io.Writer, runs []matrixRun) {
+	width := 0
+	for _, run := range runs {
+		if len(run.label) > width {
+			width = len(run.label)
+		}
+	}
+	fmt.Fprintln(out, "Matrix summary:")
+	var passing []string
+	for _, run := range runs {
+		color := Red
+		if passesAll(run.results) {
+			color = Green
+			passing = append(passing, run.label)
+		}
+		summary := strings.TrimPrefix(OnelineSummary(run.results.Tests, run.results.TotalTime), "harn: ")
+		if run.results.NotRun > 0 {
+			summary += fmt.Sprintf(", %d not run", run.results.NotRun)
+		}
+		fmt.Fprintf(out, "  %s%-*s%s  %s\n", color, width, run.label, Reset, summary)
+	}
+	if len(passing) == 0 {
+		fmt.Fprintf(out, "%sNo argument set passes every test%s\n", Red, Reset)
+	} else {
+		fmt.Fprintf(out, "%sEvery test passes with: %s%s\n", Green, strings.Join(passing, ", "), Reset)
+	}
+}
@@ -0,0 +1,212 @@
+package harn
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Diff granularities accepted by Config.DiffGranularity
+const (
+	DiffChar = "char"
+	DiffWord = "word"
+	DiffLine = "line"
+)
+
+var validDiffGranularities = map[string]bool{DiffChar: true, DiffWord: true, DiffLine: true}
+
+// diffOutputs diffs an expected and an actual output at the given granularity. Words and
+// lines are diffed as units, by first mapping each distinct one to a single rune like
+// DiffLinesToRunes does, so a changed word or line shows up replaced as a whole.
+func diffOutputs(dmp *diffmatchpatch.DiffMatchPatch, expected, actual, granularity string) []diffmatchpatch.Diff {
+	if granularity != DiffWord && granularity != DiffLine {
+		return dmp.DiffMain(expected, actual, false)
+	}
+	var units []string
+	ids := make(map[string]rune)
+	encode := func(text string) []rune {
+		var runes []rune
+		for _, unit := range splitDiffUnits(text, granularity) {
+			id, ok := ids[unit]
+			if !ok {
+				// Runes in the surrogate range don't survive the conversion to a string, they are skipped
+				id = rune(len(units))
+				if id >= 0xD800 {
+					id += 0x800
+				}
+				units = append(units, unit)
+				ids[unit] = id
+			}
+			runes = append(runes, id)
+		}
+		return runes
+	}
+	expectedRunes, actualRunes := encode(expected), encode(actual)
+	if len(units) > 0x10FFFF-0x800 {
+		return dmp.DiffMain(expected, actual, false)
+	}
+	diffs := dmp.DiffMainRunes(expectedRunes, actualRunes, false)
+	for i, diff := range diffs {
+		var text strings.Builder
+		for _, id := range diff.Text {
+			if id >= 0xE000 {
+				id -= 0x800
+			}
+			text.WriteString(units[id])
+		}
+		diffs[i].Text = text.String()
+	}
+	return diffs
+}
+
+// splitDiffUnits splits a text into lines (keeping their line breaks), or into words and
+// the runs of whitespace between them
+func splitDiffUnits(text, granularity string) []string {
+	if granularity == DiffLine {
+		units := strings.SplitAfter(text, "\n")
+		if units[len(units)-1] == "" {
+			units = units[:len(units)-1]
+		}
+		return units
+	}
+	var units []string
+	start, inSpace := 0, false
+	for i, r := range text {
+		if i > start && unicode.IsSpace(r) != inSpace {
+			units = append(units, text[start:i])
+			start = i
+		}
+		inSpace = unicode.IsSpace(r)
+	}
+	if start < len(text) {
+		units = append(units, text[start:])
+	}
+	return units
+}
+
+// prettyDiff renders a diff with colors, or with [-removed-]{+added+} markers when colors are disabled
+func prettyDiff(dmp *diffmatchpatch.DiffMatchPatch, diffs []diffmatchpatch.Diff) string {
+	if Reset != "" {
+		return dmp.DiffPrettyText(diffs)
+	}
+	var text strings.Builder
+	for _, diff := range diffs {
+		switch diff.Type {
+		case diffmatchpatch.DiffDelete:
+			text.WriteString("[-" + diff.Text + "-]")
+		case diffmatchpatch.DiffInsert:
+			text.WriteString("{+" + diff.Text + "+}")
+		default:
+			text.WriteString(diff.Text)
+		}
+	}
+	return text.String()
+}
+
+// showWhitespace makes spaces, tabs and line breaks in a diff visible as ·, → and ¶,
+// keeping the line breaks themselves so the diff still spans the same lines
+func showWhitespace(diffs []diffmatchpatch.Diff) []diffmatchpatch.Diff {
+	replacer := strings.NewReplacer(" ", "·", "\t", "→", "\n", "¶\n")
+	visible := make([]diffmatchpatch.Diff, len(diffs))
+	for i, diff := range diffs {
+		visible[i] = diffmatchpatch.Diff{Type: diff.Type, Text: replacer.Replace(diff.Text)}
+	}
+	return visible
+}
+
+// truncateLines keeps the first maxLines lines of text, reporting whether any were cut.
+// Colors are reset after a cut, since it may fall inside a colored part.
+func truncateLines(text string, maxLines int) (string, bool) {
+	if maxLines <= 0 {
+		return text, false
+	}
+	end := 0
+	for i := 0; i < maxLines; i++ {
+		next := strings.IndexByte(text[end:], '\n')
+		if next < 0 {
+			return text, false
+		}
+		end += next + 1
+	}
+	if end == len(text) {
+		return text, false
+	}
+	return text[:end-1] + Reset, true
+}
+
+// FormatMemory renders a size in bytes with the largest binary unit that keeps it above one, e.g. 1.5G
+func FormatMemory(bytes int64) string {
+	size, units := float64(bytes), []string{"", "K", "M", "G", "T"}
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	return strconv.FormatFloat(math.Round(size*100)/100, 'f', -1, 64) + units[unit]
+}
+
+// formatTime renders an execution time colored by how close it came to the timeout
+func formatTime(executionTime, timeout time.Duration, thresholds [2]float64) string {
+	color := Green
+	if fraction := float64(executionTime) / float64(timeout); fraction >= thresholds[1] {
+		color = Red
+	} else if fraction >= thresholds[0] {
+		color = Yellow
+	}
+	return color + executionTime.Round(time.Millisecond).String() + Reset
+}
+
+// alternateLabel names the i-th expected file in output headers when there is more than one
+func alternateLabel(expectedFiles []string, i int) string {
+	if len(expectedFiles) < 2 {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", expectedFiles[i])
+}
+
+// printPerfStats prints the perf stat summary collected for a test, if any
+func printPerfStats(out io.Writer, stats string) {
+	if stats == "" {
+		return
+	}
+	fmt.Fprintf(out, " === perf stat:\n%s\n", stats)
+	fmt.Fprintf(out, " === End perf stat:\n")
+}
+
+// confirmOnStdin asks a yes/no question on out, reads the answer from stdin and
+// reports whether it was yes
+func confirmOnStdin(out io.Writer, reader *bufio.Reader, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, err := reader.ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(out)
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// clearLine moves the cursor back to the start of the line and erases it, for the live summary
+const clearLine = "\r\x1b[K"
+
+// liveSummary is the "X/Y passed so far" line shown under the results during a
+// -live-summary run, without a trailing newline so the next report replaces it
+func liveSummary(results []TestResult, total int) string {
+	passedCount, failed := 0, countFailures(results)
+	for _, result := range results {
+		if passed(result.Verdict) {
+			passedCount++
+		}
+	}
+	color := Green
+	if failed > 0 {
+		color = Red
+	}
+	return fmt.Sprintf("%s%d/%d passed so far%s (%d of %d tests run)", color, passedCount, len(results), Reset, len(results), total)
+}
@@ -0,0 +1,63 @@
+package harn
+
+import (
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// cpuMask is a CPU affinity bit mask, as taken by sched_setaffinity
+type cpuMask [1024 / 64]uint64
+
+func getAffinity(mask *cpuMask) error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_GETAFFINITY, 0, unsafe.Sizeof(*mask), uintptr(unsafe.Pointer(mask)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func setAffinity(mask *cpuMask) error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, 0, unsafe.Sizeof(*mask), uintptr(unsafe.Pointer(mask)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// allowedCPUs lists the CPUs harn may run on, which are the ones programs can be pinned to
+func allowedCPUs() ([]int, error) {
+	var mask cpuMask
+	if err := getAffinity(&mask); err != nil {
+		return nil, err
+	}
+	var cpus []int
+	for cpu := 0; cpu < len(mask)*64; cpu++ {
+		if mask[cpu/64]&(1<<(cpu%64)) != 0 {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// startPinned starts a command that can only run on the given CPU. Children inherit
+// the affinity of the thread that forks them, so the calling thread is pinned just
+// for the duration of the fork.
+func startPinned(cmd *exec.Cmd, cpu int) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var previous, pinned cpuMask
+	if err := getAffinity(&previous); err != nil {
+		return err
+	}
+	pinned[cpu/64] = 1 << (cpu % 64)
+	if err := setAffinity(&pinned); err != nil {
+		return err
+	}
+	err := cmd.Start()
+	if restoreErr := setAffinity(&previous); restoreErr != nil {
+		Log.Warnf("Failed to restore CPU affinity after pinning: %v", restoreErr)
+	}
+	return err
+}
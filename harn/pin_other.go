@@ -0,0 +1,18 @@
+//go:build !linux
+
+package harn
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// allowedCPUs is only implemented on Linux
+func allowedCPUs() ([]int, error) {
+	return nil, errors.New("CPU pinning is not supported on this platform")
+}
+
+// startPinned is only implemented on Linux
+func startPinned(cmd *exec.Cmd, cpu int) error {
+	return cmd.Start()
+}
@@ -0,0 +1,17 @@
+package harn
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// pendingBytes returns how many bytes are buffered in a pipe and not yet read
+func pendingBytes(f *os.File) (int, error) {
+	var n int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCINQ, uintptr(unsafe.Pointer(&n)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package harn
+
+import (
+	"errors"
+	"os"
+)
+
+// pendingBytes is only implemented on Linux
+func pendingBytes(f *os.File) (int, error) {
+	return 0, errors.New("pending pipe bytes are not supported on this platform")
+}
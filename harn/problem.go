@@ -0,0 +1,93 @@
+package harn
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Problem holds the limits read from a problem package's metadata file
+type Problem struct {
+	// TimeLimit and MemoryLimit (in bytes) are zero when the file doesn't set them
+	TimeLimit   time.Duration
+	MemoryLimit int64
+}
+
+// ReadProblem reads the time_limit and memory_limit fields of a problem.yaml. Only
+// "key: value" lines are understood, at any indentation, so the fields may sit under
+// a limits section. Bare numbers are seconds and MiB, as in Polygon and ICPC packages;
+// values such as 1.5s or 256M are accepted too.
+func ReadProblem(filename string) (Problem, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return Problem{}, err
+	}
+	defer file.Close()
+
+	var problem Problem
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		key, value, found := strings.Cut(text, ":")
+		if !found {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.Trim(strings.TrimSpace(value), `"'`)
+		switch key {
+		case "time_limit":
+			if problem.TimeLimit, err = parseTimeLimit(value); err != nil {
+				return Problem{}, fmt.Errorf("line %d: invalid time_limit %q", line, value)
+			}
+		case "memory_limit":
+			if problem.MemoryLimit, err = parseMemoryLimit(value); err != nil {
+				return Problem{}, fmt.Errorf("line %d: invalid memory_limit %q", line, value)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Problem{}, err
+	}
+	return problem, nil
+}
+
+// parseTimeLimit parses a time limit in seconds, or a duration such as 500ms
+func parseTimeLimit(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	limit, err := time.ParseDuration(value)
+	if err == nil && limit <= 0 {
+		err = fmt.Errorf("time limit must be positive")
+	}
+	return limit, err
+}
+
+// parseMemoryLimit parses a memory limit in MiB, or a size such as 256M
+func parseMemoryLimit(value string) (int64, error) {
+	if mebibytes, err := strconv.ParseFloat(value, 64); err == nil && mebibytes > 0 {
+		return int64(mebibytes * (1 << 20)), nil
+	}
+	return ParseMemory(value)
+}
+
+// ParseMemory parses a memory size such as "512M" or "2G", with an optional K, M,
+// G or T binary suffix (case-insensitive, a trailing B or iB is allowed) and bytes otherwise
+func ParseMemory(value string) (int64, error) {
+	number := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B"), "I")
+	multiplier := int64(1)
+	if i := strings.IndexAny(number, "KMGT"); i >= 0 && i == len(number)-1 {
+		multiplier = int64(1) << (10 * (strings.IndexByte("KMGT", number[i]) + 1))
+		number = number[:i]
+	}
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("%q is not a positive size such as 512M or 2G", value)
+	}
+	return int64(size * float64(multiplier)), nil
+}
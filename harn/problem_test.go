@@ -0,0 +1,35 @@
+package harn
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadProblem(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    Problem
+		wantErr bool
+	}{
+		{"nested", "name: sum\nlimits:\n  time_limit: 2 # seconds\n  memory_limit: 256\n", Problem{2 * time.Second, 256 << 20}, false},
+		{"units", "time_limit: 500ms\nmemory_limit: \"1.5G\"\n", Problem{500 * time.Millisecond, 3 << 29}, false},
+		{"missing", "name: sum\n", Problem{}, false},
+		{"invalid", "time_limit: fast\n", Problem{}, true},
+		{"negative", "memory_limit: -1\n", Problem{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "problem.yaml")
+			if err := os.WriteFile(filename, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadProblem(filename)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ReadProblem() = %+v, %v, want %+v (error: %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
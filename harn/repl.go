@@ -0,0 +1,86 @@
+package harn
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// replCase is what the -repl prompt can show of the failing test
+type replCase struct {
+	name  string
+	input string
+	// expected holds the acceptable expected outputs, empty when the test failed before they were read
+	expected []string
+	actual   string
+}
+
+const replHelp = `  r  re-run the test, e.g. after rebuilding the program
+  i  show the input
+  e  show the expected output
+  a  show the actual output
+  c  continue with the remaining tests
+  q  stop, skipping the remaining tests
+`
+
+// repl prompts after a failing test under -repl until told to go on, and reports
+// whether to run the remaining tests. rerun runs the test once more, reporting what
+// it showed and whether it failed again.
+func repl(out io.Writer, prompt func(string) (string, bool), c replCase, rerun func() (replCase, bool)) bool {
+	fmt.Fprintf(out, "%sStopped at the failure of %s%s (? for help)\n", Yellow, c.name, Reset)
+	for {
+		command, ok := prompt("harn> ")
+		if !ok {
+			return false
+		}
+		switch strings.TrimSpace(command) {
+		case "":
+		case "r", "rerun":
+			var failed bool
+			if c, failed = rerun(); !failed {
+				fmt.Fprintf(out, "%s passes now, continuing\n", c.name)
+				return true
+			}
+		case "i", "input":
+			replSection(out, "Input", c.input)
+		case "e", "expected":
+			if len(c.expected) == 0 {
+				fmt.Fprintf(out, "No expected output was read for %s\n", c.name)
+			}
+			for i, expected := range c.expected {
+				title := "Expected"
+				if len(c.expected) > 1 {
+					title = fmt.Sprintf("Expected (%d of %d)", i+1, len(c.expected))
+				}
+				replSection(out, title, expected)
+			}
+		case "a", "actual":
+			replSection(out, "Actual", c.actual)
+		case "c", "continue":
+			return true
+		case "q", "quit":
+			return false
+		case "?", "h", "help":
+			fmt.Fprint(out, replHelp)
+		default:
+			fmt.Fprintf(out, "Unknown command %q (? for help)\n", strings.TrimSpace(command))
+		}
+	}
+}
+
+// replSection shows an input or output at the -repl prompt, framed like the diffs
+func replSection(out io.Writer, title, content string) {
+	fmt.Fprintf(out, " === %s:\n%s\n === End %s\n", title, strings.TrimSuffix(content, "\n"), title)
+}
+
+// promptOnStdin reads a line from stdin after printing the prompt on out, reporting false at the end of input
+func promptOnStdin(out io.Writer, reader *bufio.Reader, prompt string) (string, bool) {
+	fmt.Fprint(out, prompt)
+	line, err := reader.ReadString('\n')
+	if err != nil && line == "" {
+		fmt.Fprintln(out)
+		return "", false
+	}
+	return line, true
+}
@@ -0,0 +1,70 @@
+package harn
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunRepl(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"1": {"1 2", "3"},
+		"2": {"2 2", "5"},
+		"3": {"1", "1"},
+	})
+	for _, tt := range []struct {
+		commands []string
+		results  int
+		notRun   int
+	}{
+		{[]string{"i", "a", "e", "bogus", "r", "q"}, 2, 1},
+		{[]string{"r", "c"}, 3, 0},
+		{nil, 2, 1},
+	} {
+		commands := tt.commands
+		prompt := func(string) (string, bool) {
+			if len(commands) == 0 {
+				return "", false
+			}
+			command := commands[0]
+			commands = commands[1:]
+			return command + "\n", true
+		}
+		var out bytes.Buffer
+		runner := &Runner{Out: &out, Prompt: prompt, executor: fakeExecutor{}}
+		results, err := runner.Run(Config{Program: "fake", Pattern: filepath.Join(dir, "*.in"), Repl: true})
+		if err != nil {
+			t.Fatalf("Run() failed: %v", err)
+		}
+		// A re-run replaces the failing result rather than adding another
+		if len(results.Tests) != tt.results || results.NotRun != tt.notRun {
+			t.Errorf("commands %q: got %d results and %d not run, want %d and %d", tt.commands, len(results.Tests), results.NotRun, tt.results, tt.notRun)
+		}
+		if len(results.Tests) > 1 && results.Tests[1].Verdict != VerdictWA {
+			t.Errorf("commands %q: got verdict %s for 2.in, want %s", tt.commands, results.Tests[1].Verdict, VerdictWA)
+		}
+		if len(tt.commands) > 2 {
+			for _, want := range []string{" === Input:\n2 2\n", " === Actual:\n4\n", " === Expected:\n5\n", `Unknown command "bogus"`} {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("commands %q: output lacks %q:\n%s", tt.commands, want, out.String())
+				}
+			}
+		}
+	}
+}
+
+func TestPromptOnStdin(t *testing.T) {
+	var out bytes.Buffer
+	reader := bufio.NewReader(strings.NewReader("r\n"))
+	if line, ok := promptOnStdin(&out, reader, "> "); !ok || line != "r\n" {
+		t.Errorf("promptOnStdin() = %q, %v, want %q, true", line, ok, "r\n")
+	}
+	if _, ok := promptOnStdin(&out, reader, "> "); ok {
+		t.Errorf("promptOnStdin() at the end of input reported a line")
+	}
+	if out.String() != "> > \n" {
+		t.Errorf("promptOnStdin() printed %q, want %q", out.String(), "> > \n")
+	}
+}
@@ -0,0 +1,227 @@
+package harn
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Reporter receives the results of a run. Several reporters can be active at once,
+// each writing the results in its own format to its own destination.
+type Reporter interface {
+	// TestDone is called as each test finishes, in the order the tests finish
+	TestDone(result TestResult)
+	// RunDone is called once all tests have run, with the results in input order
+	RunDone(results Results) error
+}
+
+// runStats are the counters a run keeps as it goes, for the terminal summary
+type runStats struct {
+	total, passed, generated, updated int
+	slow, timedOut                    int
+	// overhead is the startup overhead measured under -overhead, zero otherwise
+	overhead time.Duration
+	// outputs sizes up what the program wrote in each successful run, for -stats
+	outputs sizeStats
+}
+
+// sizeStats sums up file or output sizes for -stats, keeping the largest so outliers stand out
+type sizeStats struct {
+	count        int
+	total        int64
+	largest      int64
+	largestInput string
+}
+
+func (s *sizeStats) add(inputFile string, size int64) {
+	s.count++
+	s.total += size
+	if s.count == 1 || size > s.largest {
+		s.largest, s.largestInput = size, inputFile
+	}
+}
+
+func (s sizeStats) String() string {
+	if s.count == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%sB total, %sB average, largest %sB (%s)", FormatMemory(s.total), FormatMemory(s.total/int64(s.count)), FormatMemory(s.largest), s.largestInput)
+}
+
+// terminalReporter prints the results of a run for humans: a line for each test as it
+// finishes, named and padded to line up, and the summary once the run is over
+type terminalReporter struct {
+	out      io.Writer
+	cfg      Config
+	stats    *runStats
+	baseline resultsFile
+	// names are the tests' names with their descriptions, padded to nameWidth
+	names     map[string]string
+	nameWidth int
+	// tests counts the tests of the run, done holds those finished for the live summary
+	tests int
+	done  []TestResult
+}
+
+func (r *terminalReporter) TestDone(result TestResult) {
+	var report strings.Builder
+	if r.cfg.LiveSummary {
+		report.WriteString(clearLine)
+	}
+	// Tests resumed from a checkpoint were reported by the run they completed in
+	if result.line != "" {
+		if r.cfg.Tagged {
+			fmt.Fprintf(&report, "%-*s ", tagWidth, result.Verdict)
+		}
+		fmt.Fprintf(&report, "%s%-*s%s - %s%s", Yellow, r.nameWidth, r.names[result.Name], Reset, result.line, result.Details)
+	}
+	if r.cfg.LiveSummary {
+		r.done = append(r.done, result)
+		report.WriteString(liveSummary(r.done, r.tests))
+	}
+	io.WriteString(r.out, report.String())
+}
+
+func (r *terminalReporter) RunDone(results Results) error {
+	out, cfg, stats := r.out, r.cfg, r.stats
+	if cfg.BaselineFile != "" {
+		printRegressions(out, r.baseline, results.Tests)
+	}
+	if cfg.ScoreByTime {
+		printScores(out, r.baseline, results.Tests)
+	}
+	if cfg.TimeHistogram {
+		printTimeHistogram(out, results.Tests)
+	}
+
+	fmt.Fprintf(out, "\n"+strings.Repeat("=", 50)+"\n")
+	if cfg.Generate {
+		fmt.Fprintf(out, "Generated %d/%d new test files\n", stats.generated, stats.total)
+		fmt.Fprintf(out, "    - %d/%d tests already exist\n", stats.passed, stats.total)
+		return nil
+	}
+
+	fmt.Fprintf(out, "Test Results: %d/%d passed\n", stats.passed, stats.total)
+	if byDir := directorySummary(results.Tests); byDir != "" {
+		fmt.Fprintf(out, "By directory: %s\n", byDir)
+	}
+	if stats.generated > 0 {
+		fmt.Fprintf(out, "    - %d new test(s) recorded in the baseline\n", stats.generated)
+	}
+	if results.NotRun > 0 && cfg.Repl && cfg.MaxFailures == 0 {
+		fmt.Fprintf(out, "    - %d test(s) not run after stopping at the -repl prompt\n", results.NotRun)
+	} else if results.NotRun > 0 {
+		fmt.Fprintf(out, "    - %d test(s) not run after reaching -max-failures\n", results.NotRun)
+	}
+	fmt.Fprintf(out, "Total execution time: %v\n", results.TotalTime)
+	if stats.total > 0 {
+		fmt.Fprintf(out, "Average execution time: %v\n", results.TotalTime/time.Duration(stats.total))
+	}
+	if percentiles := timePercentiles(results.Tests); percentiles != "" {
+		fmt.Fprintf(out, "Execution time percentiles: %s\n", percentiles)
+	}
+	if stats.overhead > 0 && stats.total > 0 {
+		var totalAdjusted time.Duration
+		for _, result := range results.Tests {
+			totalAdjusted += adjustedTime(result.Time, stats.overhead)
+		}
+		fmt.Fprintf(out, "Average adjusted time: %v (without %v startup overhead)\n", totalAdjusted/time.Duration(stats.total), stats.overhead.Round(10*time.Microsecond))
+	}
+
+	if cfg.SoftLimit > 0 {
+		fmt.Fprintf(out, "Soft limit (%v) exceeded: %d test(s)\n", cfg.SoftLimit, stats.slow)
+	}
+	fmt.Fprintf(out, "Hard timeouts (TLE): %d test(s)\n", stats.timedOut)
+	if cfg.Stats {
+		fmt.Fprintf(out, "Output sizes: %s\n", stats.outputs)
+	}
+	if cfg.Update {
+		fmt.Fprintf(out, "Updated %d expected output file(s)\n", stats.updated)
+	}
+
+	if cfg.PassThreshold > 0 {
+		status := Green + "met" + Reset
+		if !metPassThreshold(results.Tests, cfg.PassThreshold) {
+			status = Red + "not met" + Reset
+		}
+		fmt.Fprintf(out, "Pass threshold %.4g%%: %s (%.1f%% passed)\n", cfg.PassThreshold*100, status, passRate(results.Tests)*100)
+	}
+
+	flakyTests := countVerdict(results.Tests, VerdictFlaky)
+	if stats.passed == stats.total {
+		fmt.Fprintf(out, "🎉 All tests passed!\n")
+	} else if stats.passed+flakyTests == stats.total {
+		fmt.Fprintf(out, "⚠️  All tests passed except %d quarantined flaky test(s)\n", flakyTests)
+	} else {
+		fmt.Fprintf(out, "💥 %d test(s) failed\n", stats.total-stats.passed-flakyTests)
+		if flakyTests > 0 {
+			fmt.Fprintf(out, "    - %d quarantined flaky test(s) also failed\n", flakyTests)
+		}
+	}
+	return nil
+}
+
+// directorySummary gives the pass counts of each directory holding tests, like
+// "easy/: 10/10, hard/: 3/8" in input order, or "" when they're all in one directory
+func directorySummary(tests []TestResult) string {
+	var dirs []string
+	passedIn, totalIn := make(map[string]int), make(map[string]int)
+	for _, result := range tests {
+		dir := filepath.ToSlash(filepath.Dir(result.Name)) + "/"
+		if totalIn[dir] == 0 {
+			dirs = append(dirs, dir)
+		}
+		totalIn[dir]++
+		if passed(result.Verdict) {
+			passedIn[dir]++
+		}
+	}
+	if len(dirs) < 2 {
+		return ""
+	}
+	counts := make([]string, len(dirs))
+	for i, dir := range dirs {
+		counts[i] = fmt.Sprintf("%s: %d/%d", dir, passedIn[dir], totalIn[dir])
+	}
+	return strings.Join(counts, ", ")
+}
+
+// githubReporter prints GitHub Actions annotations for the failing tests under -github
+type githubReporter struct {
+	out io.Writer
+}
+
+func (githubReporter) TestDone(result TestResult) {}
+
+func (r githubReporter) RunDone(results Results) error {
+	printGitHubAnnotations(r.out, results.Tests)
+	return nil
+}
+
+// quickfixReporter prints editor quickfix lines for the failing tests under -quickfix
+type quickfixReporter struct {
+	out io.Writer
+}
+
+func (quickfixReporter) TestDone(result TestResult) {}
+
+func (r quickfixReporter) RunDone(results Results) error {
+	printQuickfix(r.out, results.Tests)
+	return nil
+}
+
+// jsonReporter writes the results to a JSON file under -json
+type jsonReporter struct {
+	filename string
+}
+
+func (jsonReporter) TestDone(result TestResult) {}
+
+func (r jsonReporter) RunDone(results Results) error {
+	if err := writeResults(r.filename, results.Tests); err != nil {
+		return fmt.Errorf("writing JSON results to %s: %v", r.filename, err)
+	}
+	return nil
+}
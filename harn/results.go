@@ -0,0 +1,596 @@
+package harn
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Verdicts recorded for each test
+const (
+	VerdictAC   = "AC"
+	VerdictSlow = "SLOW"
+	VerdictWA   = "WA"
+	VerdictTLE  = "TLE"
+	// VerdictRE is a runtime error, the program exited with a code not listed in Config.OkCodes
+	VerdictRE = "RE"
+	// VerdictStderr fails a test whose program wrote to stderr, under Config.NoStderr
+	VerdictStderr = "STDERR"
+	// VerdictNondeterministic fails a test whose program gave different outputs in two runs, under Config.CheckDeterminism
+	VerdictNondeterministic = "NONDETERMINISTIC"
+	VerdictErr              = "ERR"
+	VerdictGen              = "GEN"
+	VerdictSkip             = "SKIP"
+	// VerdictFlaky replaces the failing verdict of a quarantined test
+	VerdictFlaky = "FLAKY"
+)
+
+// tagWidth fits the longest verdict, so -tagged tokens line up
+const tagWidth = len(VerdictNondeterministic)
+
+// A test is considered significantly slower than its baseline when it takes
+// slowdownFactor times as long and at least slowdownMinDelta more
+const (
+	slowdownFactor   = 1.5
+	slowdownMinDelta = 10 * time.Millisecond
+)
+
+// TestResult records the outcome of a single test
+type TestResult struct {
+	Name    string        `json:"name"`
+	Verdict string        `json:"verdict"`
+	Time    time.Duration `json:"time_ns"`
+	// Message explains why a test failed
+	Message string `json:"message,omitempty"`
+	// Details are the sections shown below the test's result line in the terminal,
+	// like its diff, its stderr or how to reproduce it
+	Details string `json:"-"`
+	// line is the result line of the test in the terminal, after its name
+	line string
+	// quickfix is the "file:line: message" of a WA for -quickfix, pointing at the first difference
+	quickfix string
+}
+
+// resultsFile is the JSON document written by -json and read by -baseline
+type resultsFile struct {
+	Passed int          `json:"passed"`
+	Total  int          `json:"total"`
+	Tests  []TestResult `json:"tests"`
+}
+
+// passed reports whether a verdict counts as a passing test
+func passed(verdict string) bool {
+	return verdict == VerdictAC || verdict == VerdictSlow
+}
+
+// sortResults orders results like the inputs they came from, since parallel tests complete in any order
+func sortResults(results []TestResult, inputFiles []string) {
+	order := make(map[string]int, len(inputFiles))
+	for i, inputFile := range inputFiles {
+		order[inputFile] = i
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return order[results[i].Name] < order[results[j].Name]
+	})
+}
+
+// countVerdict counts the results with the given verdict
+func countVerdict(results []TestResult, verdict string) int {
+	count := 0
+	for _, result := range results {
+		if result.Verdict == verdict {
+			count++
+		}
+	}
+	return count
+}
+
+// countFailures counts the results that should make the run fail. Generated,
+// skipped and quarantined tests never do.
+func countFailures(results []TestResult) int {
+	count := 0
+	for _, result := range results {
+		switch result.Verdict {
+		case VerdictGen, VerdictSkip, VerdictFlaky:
+		default:
+			if !passed(result.Verdict) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// OnelineSummary condenses a run into a single line such as "harn: 47/50 AC, 2 WA, 1 TLE, 3.2s"
+func OnelineSummary(results []TestResult, totalTime time.Duration) string {
+	counts := make(map[string]int)
+	for _, result := range results {
+		counts[result.Verdict]++
+	}
+	var parts []string
+	if counts[VerdictGen]+counts[VerdictSkip] > 0 {
+		parts = append(parts, fmt.Sprintf("%d/%d GEN", counts[VerdictGen], len(results)))
+		if counts[VerdictSkip] > 0 {
+			parts = append(parts, fmt.Sprintf("%d SKIP", counts[VerdictSkip]))
+		}
+	} else {
+		parts = append(parts, fmt.Sprintf("%d/%d AC", counts[VerdictAC]+counts[VerdictSlow], len(results)))
+	}
+	for _, verdict := range []string{VerdictSlow, VerdictWA, VerdictTLE, VerdictRE, VerdictStderr, VerdictNondeterministic, VerdictErr, VerdictFlaky} {
+		if counts[verdict] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[verdict], verdict))
+		}
+	}
+	parts = append(parts, fmt.Sprintf("%.1fs", totalTime.Seconds()))
+	return "harn: " + strings.Join(parts, ", ")
+}
+
+// passRate is the fraction of tests that passed, quarantined tests aren't counted
+func passRate(results []TestResult) float64 {
+	total := len(results) - countVerdict(results, VerdictFlaky)
+	if total == 0 {
+		return 1
+	}
+	return float64(total-countFailures(results)) / float64(total)
+}
+
+// metPassThreshold reports whether enough tests passed for the run to succeed
+func metPassThreshold(results []TestResult, threshold float64) bool {
+	return passRate(results) >= threshold
+}
+
+// writeResults saves the results of a run as JSON
+func writeResults(filename string, results []TestResult) error {
+	doc := resultsFile{Total: len(results), Tests: results}
+	for _, result := range results {
+		if passed(result.Verdict) {
+			doc.Passed++
+		}
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// readResults loads a JSON results file written by writeResults
+func readResults(filename string) (resultsFile, error) {
+	var doc resultsFile
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return doc, err
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return doc, fmt.Errorf("invalid results file %s: %v", filename, err)
+	}
+	return doc, nil
+}
+
+// printRegressions compares the current results against a baseline run and
+// prints tests whose verdict changed or that became significantly slower
+func printRegressions(out io.Writer, baseline resultsFile, results []TestResult) {
+	previous := make(map[string]TestResult, len(baseline.Tests))
+	for _, result := range baseline.Tests {
+		previous[result.Name] = result
+	}
+
+	var newlyFailing, newlyPassing, slower []string
+	for _, result := range results {
+		old, ok := previous[result.Name]
+		if !ok {
+			continue
+		}
+		switch {
+		case passed(old.Verdict) && !passed(result.Verdict):
+			newlyFailing = append(newlyFailing, fmt.Sprintf("%s (%s -> %s)", result.Name, old.Verdict, result.Verdict))
+		case !passed(old.Verdict) && passed(result.Verdict):
+			newlyPassing = append(newlyPassing, fmt.Sprintf("%s (%s -> %s)", result.Name, old.Verdict, result.Verdict))
+		}
+		if passed(old.Verdict) && passed(result.Verdict) &&
+			float64(result.Time) >= float64(old.Time)*slowdownFactor && result.Time-old.Time >= slowdownMinDelta {
+			slower = append(slower, fmt.Sprintf("%s (%v -> %v)", result.Name,
+				old.Time.Round(time.Millisecond), result.Time.Round(time.Millisecond)))
+		}
+	}
+
+	fmt.Fprintf(out, "\nChanges since baseline:\n")
+	if len(newlyFailing)+len(newlyPassing)+len(slower) == 0 {
+		fmt.Fprintf(out, "    (no verdict changes or slowdowns)\n")
+		return
+	}
+	for _, name := range newlyFailing {
+		fmt.Fprintf(out, "    %sNEWLY FAILING%s %s\n", Red, Reset, name)
+	}
+	for _, name := range newlyPassing {
+		fmt.Fprintf(out, "    %sNEWLY PASSING%s %s\n", Green, Reset, name)
+	}
+	for _, name := range slower {
+		fmt.Fprintf(out, "    %sSLOWER%s %s\n", Yellow, Reset, name)
+	}
+}
+
+// maxTimeScore caps the score of a single test under -score-by-time, so that a test
+// too quick to time reliably can't dominate the total
+const maxTimeScore = 10.0
+
+// timeScore scores a result against its baseline under -score-by-time: the baseline
+// time divided by its time if it passed, zero otherwise. It reports false when the
+// baseline has no passing time to score against.
+func timeScore(result, baseline TestResult) (float64, bool) {
+	if !passed(baseline.Verdict) || baseline.Time <= 0 {
+		return 0, false
+	}
+	if !passed(result.Verdict) {
+		return 0, true
+	}
+	if result.Time <= 0 || float64(baseline.Time)/float64(result.Time) > maxTimeScore {
+		return maxTimeScore, true
+	}
+	return float64(baseline.Time) / float64(result.Time), true
+}
+
+// printScores prints the -score-by-time score of each test and their total, which
+// the baseline itself would get one point per test for
+func printScores(out io.Writer, baseline resultsFile, results []TestResult) {
+	previous := make(map[string]TestResult, len(baseline.Tests))
+	for _, result := range baseline.Tests {
+		previous[result.Name] = result
+	}
+	width := 0
+	for _, result := range results {
+		if len(result.Name) > width {
+			width = len(result.Name)
+		}
+	}
+
+	fmt.Fprintf(out, "\nScores by time (1.00 is as fast as the baseline):\n")
+	total, scored := 0.0, 0
+	for _, result := range results {
+		old, ok := previous[result.Name]
+		score, scorable := timeScore(result, old)
+		switch {
+		case !ok || !scorable:
+			fmt.Fprintf(out, "    %-*s     -  (no passing baseline time)\n", width, result.Name)
+			continue
+		case !passed(result.Verdict):
+			fmt.Fprintf(out, "    %-*s  %s%4.2f%s  (%s)\n", width, result.Name, Red, score, Reset, result.Verdict)
+		default:
+			color := Green
+			if score < 1 {
+				color = Yellow
+			}
+			fmt.Fprintf(out, "    %-*s  %s%4.2f%s  (%v vs %v)\n", width, result.Name, color, score, Reset,
+				result.Time.Round(10*time.Microsecond), old.Time.Round(10*time.Microsecond))
+		}
+		total += score
+		scored++
+	}
+	fmt.Fprintf(out, "Performance score: %.2f over %d test(s), the baseline scores %d\n", total, scored, scored)
+}
+
+// Execution time histograms have at most histogramBuckets rows and bars of up to histogramWidth characters
+const (
+	histogramBuckets = 10
+	histogramWidth   = 40
+)
+
+// printTimeHistogram buckets the tests that ran by execution time and prints
+// the distribution as horizontal bars
+func printTimeHistogram(out io.Writer, results []TestResult) {
+	times := executionTimes(results)
+	fmt.Fprintf(out, "\nExecution time histogram:\n")
+	if len(times) == 0 {
+		fmt.Fprintf(out, "    (no tests were run)\n")
+		return
+	}
+
+	lowest, highest := times[0], times[0]
+	for _, t := range times {
+		if t < lowest {
+			lowest = t
+		}
+		if t > highest {
+			highest = t
+		}
+	}
+	buckets := histogramBuckets
+	if len(times) < buckets {
+		buckets = len(times)
+	}
+	width := (highest - lowest) / time.Duration(buckets)
+	if width <= 0 {
+		buckets, width = 1, 1
+	}
+
+	counts := make([]int, buckets)
+	for _, t := range times {
+		bucket := int((t - lowest) / width)
+		if bucket >= buckets {
+			bucket = buckets - 1
+		}
+		counts[bucket]++
+	}
+	largest := 0
+	for _, count := range counts {
+		if count > largest {
+			largest = count
+		}
+	}
+
+	labels := make([]string, buckets)
+	labelWidth := 0
+	for i := range counts {
+		from := lowest + time.Duration(i)*width
+		to := from + width
+		if i == buckets-1 {
+			to = highest
+		}
+		labels[i] = fmt.Sprintf("%v - %v", roundDuration(from), roundDuration(to))
+		if len(labels[i]) > labelWidth {
+			labelWidth = len(labels[i])
+		}
+	}
+	for i, count := range counts {
+		bar := strings.Repeat("#", (count*histogramWidth+largest-1)/largest)
+		fmt.Fprintf(out, "    %-*s | %s %d\n", labelWidth, labels[i], bar, count)
+	}
+}
+
+// executionTimes lists the execution times of the tests that ran
+func executionTimes(results []TestResult) []time.Duration {
+	var times []time.Duration
+	for _, result := range results {
+		if result.Verdict != VerdictSkip {
+			times = append(times, result.Time)
+		}
+	}
+	return times
+}
+
+// percentile returns the nearest-rank p-th percentile of times sorted in ascending order
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// timePercentiles summarizes typical and worst-case execution times, which the
+// average hides when a few tests are much slower than the rest
+func timePercentiles(results []TestResult) string {
+	times := executionTimes(results)
+	if len(times) == 0 {
+		return ""
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return fmt.Sprintf("p50 %v, p90 %v, p99 %v, max %v", roundDuration(percentile(times, 50)),
+		roundDuration(percentile(times, 90)), roundDuration(percentile(times, 99)), roundDuration(times[len(times)-1]))
+}
+
+// roundDuration shortens a duration for display, keeping three significant digits or so
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond)
+	case d >= time.Millisecond:
+		return d.Round(100 * time.Microsecond)
+	default:
+		return d.Round(time.Microsecond)
+	}
+}
+
+// StateFileName is the name of the -state file harn keeps next to the tests by default
+const StateFileName = ".harn-failures"
+
+// DefaultStateFile is where failing tests are remembered unless -state says otherwise:
+// in the deepest directory of the pattern that comes before any wildcard, so each
+// suite keeps its own list. Tests read from stdin or only from -cases use the current directory.
+func DefaultStateFile(pattern string) string {
+	if pattern == "" || pattern == "-" {
+		return StateFileName
+	}
+	dir := filepath.Dir(filepath.FromSlash(pattern))
+	for strings.ContainsAny(dir, "*?[") {
+		dir = filepath.Dir(dir)
+	}
+	return filepath.Join(dir, StateFileName)
+}
+
+// readFailures loads the set of test names saved by writeFailures, a missing file is an empty set
+func readFailures(filename string) (map[string]bool, error) {
+	failures := make(map[string]bool)
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return failures, nil
+	} else if err != nil {
+		return failures, err
+	}
+	for _, name := range strings.Split(string(data), "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			failures[name] = true
+		}
+	}
+	return failures, nil
+}
+
+// writeFailures saves the names of the failing tests, one per line. Tests that
+// weren't run this time keep their previous state.
+func writeFailures(filename string, previous map[string]bool, results []TestResult) error {
+	failures := make(map[string]bool, len(previous))
+	for name := range previous {
+		failures[name] = true
+	}
+	for _, result := range results {
+		failures[result.Name] = !passed(result.Verdict)
+	}
+
+	var names []string
+	for name, failing := range failures {
+		if failing {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return os.WriteFile(filename, nil, 0644)
+	}
+	return os.WriteFile(filename, []byte(strings.Join(names, "\n")+"\n"), 0644)
+}
+
+// readQuarantine loads the list of known-flaky tests. Each line holds a test name,
+// optionally followed by a YYYY-MM-DD date after which the entry expires; blank
+// lines and lines starting with # are ignored.
+func readQuarantine(filename string, now time.Time) (map[string]bool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	quarantined := make(map[string]bool)
+	for i, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 1 {
+			expires, err := time.ParseInLocation("2006-01-02", fields[1], time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid expiry date %q (expected YYYY-MM-DD)", filename, i+1, fields[1])
+			}
+			if !now.Before(expires.AddDate(0, 0, 1)) {
+				Log.Warnf("Quarantine of %s expired on %s, treating it as a normal test", fields[0], fields[1])
+				continue
+			}
+		}
+		quarantined[fields[0]] = true
+	}
+	return quarantined, nil
+}
+
+// checkpoint appends each completed test to a file so that an interrupted run can
+// be resumed, skipping the tests it already completed
+type checkpoint struct {
+	file *os.File
+	done map[string]TestResult
+}
+
+// openCheckpoint loads the tests completed by a previous run, if any, and opens the
+// checkpoint file for appending new results
+func openCheckpoint(filename string) (*checkpoint, error) {
+	c := &checkpoint{done: make(map[string]TestResult)}
+	data, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		var result TestResult
+		// A partially written last line from an interrupted run is ignored
+		if err := json.Unmarshal([]byte(line), &result); err == nil {
+			c.done[result.Name] = result
+		}
+	}
+
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		// Terminate the partial line so new results start on their own line
+		if _, err := file.Write([]byte("\n")); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	c.file = file
+	return c, nil
+}
+
+// completed returns the result of a test finished before the run was resumed
+func (c *checkpoint) completed(name string) (TestResult, bool) {
+	if c == nil {
+		return TestResult{}, false
+	}
+	result, ok := c.done[name]
+	return result, ok
+}
+
+// add records a completed test
+func (c *checkpoint) add(result TestResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = c.file.Write(append(data, '\n'))
+	return err
+}
+
+// finish deletes the checkpoint once the whole run has completed
+func (c *checkpoint) finish() error {
+	c.file.Close()
+	return os.Remove(c.file.Name())
+}
+
+// printGitHubAnnotations prints a GitHub Actions workflow command for each failing
+// test, so failures show up as annotations on the pull request
+func printGitHubAnnotations(out io.Writer, results []TestResult) {
+	for _, result := range results {
+		if passed(result.Verdict) || result.Verdict == VerdictGen || result.Verdict == VerdictSkip {
+			continue
+		}
+		command := "error"
+		if result.Verdict == VerdictFlaky {
+			command = "warning"
+		}
+		fmt.Fprintf(out, "::%s file=%s,title=%s::%s\n", command, escapeAnnotationProperty(result.Name),
+			escapeAnnotationProperty(result.Verdict+" "+result.Name), escapeAnnotationData(result.Message))
+	}
+}
+
+// printQuickfix prints a "file:line: message" line for each failing test, which
+// Vim's quickfix list and Emacs' compilation mode can jump to. Wrong answers point
+// at the first differing line of the expected file, other failures at the input.
+func printQuickfix(out io.Writer, results []TestResult) {
+	for _, result := range results {
+		if passed(result.Verdict) || result.Verdict == VerdictGen || result.Verdict == VerdictSkip {
+			continue
+		}
+		if result.quickfix != "" {
+			fmt.Fprintln(out, result.quickfix)
+			continue
+		}
+		message := strings.SplitN(result.Message, "\n", 2)[0]
+		fmt.Fprintf(out, "%s:1: %s %s: %s\n", result.Name, result.Verdict, result.Name, message)
+	}
+}
+
+// quickfixLine locates a wrong answer at the first differing line of its expected
+// file, described by the comparison's mismatch or else by the two differing lines
+func quickfixLine(result TestResult, expectedFile, expected, actual, mismatch string, binary bool) string {
+	line, expectedLine, actualLine := 1, "", ""
+	if !binary {
+		if differingLine, e, a := firstDifference(expected, actual); differingLine > 0 {
+			line, expectedLine, actualLine = differingLine, e, a
+		}
+	}
+	if mismatch == "" {
+		mismatch = fmt.Sprintf("expected %q, got %q", expectedLine, actualLine)
+	}
+	return fmt.Sprintf("%s:%d: %s %s: %s", expectedFile, line, result.Verdict, result.Name, mismatch)
+}
+
+// escapeAnnotationData escapes the message of a workflow command
+func escapeAnnotationData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeAnnotationProperty escapes a property value of a workflow command
+func escapeAnnotationProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
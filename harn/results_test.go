@@ -0,0 +1,83 @@
+package harn
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuickfixLine(t *testing.T) {
+	result := TestResult{Name: "tests/1.in", Verdict: VerdictWA}
+	tests := []struct {
+		expected, actual, mismatch string
+		binary                     bool
+		want                       string
+	}{
+		{"1\n2\n3", "1\n2\n4", "", false, `tests/1.out:3: WA tests/1.in: expected "3", got "4"`},
+		{"1\n2", "1", "", false, `tests/1.out:2: WA tests/1.in: expected "2", got ""`},
+		{"1 2\n3", "1 2\n4", `token 3: expected "3", got "4"`, false, `tests/1.out:2: WA tests/1.in: token 3: expected "3", got "4"`},
+		{"abc", "abd", "first difference at byte offset 2, 0x2", true, "tests/1.out:1: WA tests/1.in: first difference at byte offset 2, 0x2"},
+	}
+	for _, tt := range tests {
+		if got := quickfixLine(result, "tests/1.out", tt.expected, tt.actual, tt.mismatch, tt.binary); got != tt.want {
+			t.Errorf("quickfixLine(%q, %q) = %q, want %q", tt.expected, tt.actual, got, tt.want)
+		}
+	}
+}
+
+func TestTimePercentiles(t *testing.T) {
+	var results []TestResult
+	for i := 1; i <= 100; i++ {
+		results = append(results, TestResult{Verdict: VerdictAC, Time: time.Duration(i) * time.Millisecond})
+	}
+	results = append(results, TestResult{Verdict: VerdictSkip})
+	if got, want := timePercentiles(results), "p50 50ms, p90 90ms, p99 99ms, max 100ms"; got != want {
+		t.Errorf("timePercentiles() = %q, want %q", got, want)
+	}
+	if got := timePercentiles(results[:1]); got != "p50 1ms, p90 1ms, p99 1ms, max 1ms" {
+		t.Errorf("timePercentiles() of a single test = %q", got)
+	}
+	if got := timePercentiles(nil); got != "" {
+		t.Errorf("timePercentiles() without tests = %q, want nothing", got)
+	}
+}
+
+func TestTimeScore(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		result, baseline TestResult
+		want             float64
+		scorable         bool
+	}{
+		{TestResult{Verdict: VerdictAC, Time: 10 * ms}, TestResult{Verdict: VerdictAC, Time: 20 * ms}, 2, true},
+		{TestResult{Verdict: VerdictSlow, Time: 40 * ms}, TestResult{Verdict: VerdictAC, Time: 20 * ms}, 0.5, true},
+		{TestResult{Verdict: VerdictWA, Time: 1 * ms}, TestResult{Verdict: VerdictAC, Time: 20 * ms}, 0, true},
+		{TestResult{Verdict: VerdictAC, Time: 1 * time.Microsecond}, TestResult{Verdict: VerdictAC, Time: 20 * ms}, maxTimeScore, true},
+		{TestResult{Verdict: VerdictAC, Time: 10 * ms}, TestResult{Verdict: VerdictTLE, Time: 20 * ms}, 0, false},
+		{TestResult{Verdict: VerdictAC, Time: 10 * ms}, TestResult{}, 0, false},
+	}
+	for _, tt := range tests {
+		got, scorable := timeScore(tt.result, tt.baseline)
+		if got != tt.want || scorable != tt.scorable {
+			t.Errorf("timeScore(%+v, %+v) = %v, %v; want %v, %v", tt.result, tt.baseline, got, scorable, tt.want, tt.scorable)
+		}
+	}
+}
+
+func TestDefaultStateFile(t *testing.T) {
+	tests := map[string]string{
+		"tests/*.in":          "tests/.harn-failures",
+		"tests/easy/[0-9].in": "tests/easy/.harn-failures",
+		"tests/*/big/*.in":    "tests/.harn-failures",
+		"*/*.in":              ".harn-failures",
+		"*.in":                ".harn-failures",
+		"/srv/suite/*.in":     "/srv/suite/.harn-failures",
+		"-":                   ".harn-failures",
+		"":                    ".harn-failures",
+	}
+	for pattern, want := range tests {
+		if got := DefaultStateFile(pattern); got != filepath.FromSlash(want) {
+			t.Errorf("DefaultStateFile(%q) = %q, want %q", pattern, got, filepath.FromSlash(want))
+		}
+	}
+}
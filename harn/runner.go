@@ -0,0 +1,1151 @@
+package harn
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes a test run. The zero value of most fields matches the
+// default of the corresponding command line flag, noted next to each field.
+type Config struct {
+	// Program is the program under test and Pattern the glob matching its inputs
+	Program string
+	Pattern string
+
+	IncludeHidden bool // -include-hidden, Pattern's wildcards skip dot-prefixed files and directories otherwise
+	InlineDesc    bool // -inline-desc, descriptions come only from .desc files otherwise
+
+	Verbose      bool          // -v
+	Silent       bool          // -s
+	Timeout      time.Duration // -t, 30s when zero
+	SoftLimit    time.Duration // -softlimit
+	CPULimit     time.Duration // -cpulimit (Unix only)
+	IdleTimeout  time.Duration // -idle-timeout, the program may go without output for the whole Timeout when zero
+	Delay        time.Duration // -delay, only between tests run one at a time
+	MemLimit     int64         // -m in bytes (Unix only), no limit when zero
+	MemBudget    int64         // -mem-budget in bytes, caps Jobs to MemBudget / MemLimit
+	Generate     bool          // -g
+	GenWith      string        // -gen-with, -g runs Program when empty
+	PerPlatform  bool          // -per-platform
+	Force        bool          // -f
+	Hash         bool          // -h
+	HashManifest string        // -hash-manifest, implies Hash; each test has its own .hash file when empty
+	SelfBaseline string        // -self-baseline, a directory of the program's recorded outputs
+
+	Interpreter []string // -interpreter, split into arguments; detected from Program's extension when empty
+	Overhead    []string // -overhead, a no-op command split into arguments
+	Pin         bool     // -pin (Linux only)
+	StrictInput bool     // -strict-input (Linux only)
+	Dir         string   // -cwd
+	OutputFile  string   // -outfile-mode, {name} is the test's name; the program writes to stdout when empty
+	OutputDir   bool     // -outdir-mode
+	Subtasks    bool     // -subtask-sections
+	CasesFile   string   // -cases, a manifest of inline tests run after those matching Pattern
+	Expect      string   // -expect, with escapes already interpreted; the expected output of a "-" pattern's test
+	ExpectFile  string   // -expect-file, read for the expected output of a "-" pattern's test
+	OkCodes     []int    // -ok-codes, only 0 when empty
+	SlowStdin   bool     // -slow-stdin
+	Perf        bool     // -perf
+	Pre         string   // -pre
+	Post        string   // -post
+	StdinAppend string   // -stdin-append, with escapes already interpreted
+
+	Trim            string  // -trim, full when empty
+	NumericEqual    bool    // -numeric-equal
+	IntFloatEqual   bool    // -int-float-equal
+	LeadingZeros    bool    // -ignore-leading-zeros
+	Eps             float64 // -eps
+	Rows            bool    // -rows
+	Round           *int    // -round, numbers aren't rounded when nil
+	Contains        bool    // -contains
+	Multiset        bool    // -multiset
+	MaxEdits        *int    // -max-edits, outputs have to match exactly when nil
+	ExtraLines      bool    // -allow-extra-lines
+	AssertSorted    string  // -assert-sorted, SortedLines or SortedTokens; the order isn't checked when empty
+	Columns         []int   // -columns, 1-based, every column is compared when empty
+	CommentPrefix   string  // -ignore-comments and -comment-prefix, comments are compared when empty
+	StripANSI       bool    // -strip-ansi
+	Delims          string  // -delim, with escapes already interpreted
+	Binary          bool    // -binary
+	WarnWhitespace  bool    // -warn-whitespace
+	Expr            bool    // -expr
+	MatchExpected   bool    // -match-expected, Pattern matches expected files instead of inputs
+	OutTemplate     string  // -out-template, expected files sit next to their inputs when empty
+	RequireExpected bool    // -require-expected, fail before running anything if an expected file is missing
+	ExpectedCmd     string  // -expected-cmd, its output replaces the expected files when set
+
+	MaxDiffLines     int     // -max-diff-lines, diffs aren't truncated when zero
+	ShowWhitespace   bool    // -show-whitespace
+	DiffGranularity  string  // -diff-granularity, char when empty
+	GitHub           bool    // -github
+	Quickfix         bool    // -quickfix
+	ReproHint        bool    // -repro-hint
+	NoStderr         bool    // -no-stderr
+	StderrBuffer     int64   // -stderr-buffer, in bytes; 1MB when zero
+	CheckDeterminism bool    // -check-determinism
+	Repl             bool    // -repl, tests then run one at a time
+	JSONFile         string  // -json
+	BaselineFile     string  // -baseline
+	ScoreByTime      bool    // -score-by-time, scoring against the times of BaselineFile
+	StateFile        string  // -state, failing tests aren't remembered when empty
+	NewFailuresOnly  bool    // -new-failures-only
+	RerunFailed      bool    // -rerun-failed
+	Tagged           bool    // -tagged
+	Stats            bool    // -stats
+	Tag              string  // -tag, all tests run when empty
+	QuarantineFile   string  // -quarantine
+	MaxFailures      int     // -max-failures
+	ResumeFile       string  // -resume
+	PassThreshold    float64 // -pass-threshold
+
+	Update     bool // -u
+	AssumeYes  bool // -y
+	ShowConfig bool // -show-config, prints the resolved configuration instead of running any test
+
+	Jobs          int        // -j, tests run one at a time when below 2
+	Sample        int        // -sample
+	Seed          int64      // -seed, random when zero
+	SeedArg       string     // -seed-arg, SeedArgIndex or SeedArgInput; no seed is passed when empty
+	ProgramArgs   []string   // the arguments after --, passed to the program before any -seed-arg
+	Matrix        [][]string // -matrix, argument sets after ProgramArgs that each run the whole suite
+	KeepTemp      bool       // -keep-temp
+	TimeColors    [2]float64 // -time-colors, 0.5,0.9 when zero
+	TimeHistogram bool       // -time-histogram
+	// LiveSummary keeps a "X/Y passed so far" line below the results, it is meant for terminals
+	LiveSummary bool // -live-summary
+}
+
+// Defaults used for zero Config fields
+const (
+	defaultTimeout = 30 * time.Second
+)
+
+var defaultTimeColors = [2]float64{0.5, 0.9}
+
+// Results is the outcome of a run
+type Results struct {
+	Tests     []TestResult
+	TotalTime time.Duration
+	// NotRun counts the tests skipped after reaching MaxFailures
+	NotRun int
+	// Failed reports whether the run failed, taking PassThreshold into account
+	Failed bool
+}
+
+// Runner runs test suites, printing human-readable results as it goes
+type Runner struct {
+	// Out receives the results, os.Stdout when nil
+	Out io.Writer
+	// Confirm asks whether an expected file may be overwritten under Update,
+	// prompting on stdin when nil
+	Confirm func(question string) bool
+	// Reporters receive the results along with the built-in terminal output, and
+	// the reporters of -json, -github and -quickfix
+	Reporters []Reporter
+	// Terminal replaces the built-in terminal output, the result line of each test and
+	// the summary printed to Out. A reporter that ignores the results silences it.
+	Terminal Reporter
+	// Prompt reads a command at the -repl prompt, from stdin when nil. It reports
+	// false at the end of input.
+	Prompt func(prompt string) (string, bool)
+	// Stdin is read for the input of the single test run when Pattern is "-",
+	// os.Stdin when nil
+	Stdin io.Reader
+
+	// executor runs the program, tests replace it with a fake
+	executor executor
+}
+
+// Run runs a test suite with a default Runner
+func Run(cfg Config) (Results, error) {
+	return (&Runner{}).Run(cfg)
+}
+
+// Run runs the program on every input matching cfg.Pattern and compares its
+// output with the expected files. An error is returned when the configuration is
+// invalid; failing tests are reported in the results instead.
+func (r *Runner) Run(cfg Config) (Results, error) {
+	if len(cfg.Matrix) > 0 {
+		return r.runMatrix(cfg)
+	}
+	cfg = withDefaults(cfg)
+	if err := validate(cfg); err != nil {
+		return Results{}, err
+	}
+	rn, err := r.newRun(cfg)
+	if err != nil {
+		return Results{}, err
+	}
+	inputFiles, err := rn.discover()
+	if err != nil {
+		return Results{}, err
+	}
+	inputFiles, ok := rn.selectTests(inputFiles)
+	if !ok {
+		return Results{}, nil
+	}
+	if err := rn.checkExpected(inputFiles); err != nil {
+		return Results{}, err
+	}
+
+	if rn.opts.temp, err = newTempStore(rn.cfg.KeepTemp); err != nil {
+		return Results{}, fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	defer rn.opts.temp.cleanup()
+	if rn.cfg.KeepTemp {
+		fmt.Fprintf(rn.out, "Keeping temporary files in %s\n", rn.opts.temp.dir)
+	}
+
+	if rn.cfg.ShowConfig {
+		printConfig(rn.out, rn.cfg, rn.programPath, rn.cmpOpts, inputFiles, rn.globPattern)
+		return Results{}, nil
+	}
+	if len(inputFiles) == 0 && rn.cfg.CasesFile != "" {
+		Log.Warnf("No tests found matching pattern %q or in %s", rn.globPattern, rn.cfg.CasesFile)
+		fmt.Fprintf(rn.out, "No tests found matching pattern %q or in %s\n", rn.globPattern, rn.cfg.CasesFile)
+		return Results{}, nil
+	} else if len(inputFiles) == 0 {
+		Log.Warnf("No files found matching pattern %q", rn.globPattern)
+		fmt.Fprintf(rn.out, "No files found matching pattern: %s\n", rn.globPattern)
+		return Results{}, nil
+	}
+
+	if err := rn.setUp(inputFiles); err != nil {
+		return Results{}, err
+	}
+	inputFiles = rn.sample(inputFiles)
+	rn.describe(inputFiles)
+	if err := rn.openReports(len(inputFiles), r.Terminal, r.Reporters); err != nil {
+		return Results{}, err
+	}
+	rn.schedule(inputFiles)
+	return rn.finish(), nil
+}
+
+// withDefaults fills in the zero fields of a configuration that have a default
+func withDefaults(cfg Config) Config {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.Trim == "" {
+		cfg.Trim = TrimFull
+	}
+	if cfg.DiffGranularity == "" {
+		cfg.DiffGranularity = DiffChar
+	}
+	if cfg.TimeColors == [2]float64{} {
+		cfg.TimeColors = defaultTimeColors
+	}
+	return cfg
+}
+
+// validate rejects invalid values and combinations of options, without touching
+// the program or any file. cfg has its defaults filled in already.
+func validate(cfg Config) error {
+	if !validDiffGranularities[cfg.DiffGranularity] {
+		return fmt.Errorf("invalid -diff-granularity value %q (expected char, word or line)", cfg.DiffGranularity)
+	}
+	if cfg.TimeColors[0] < 0 || cfg.TimeColors[0] > cfg.TimeColors[1] {
+		return fmt.Errorf("invalid -time-colors: the yellow fraction %v must be between 0 and the red fraction %v", cfg.TimeColors[0], cfg.TimeColors[1])
+	}
+	if !validTrimModes[cfg.Trim] {
+		return fmt.Errorf("invalid -trim value %q (expected full, blank-lines, collapse-spaces or none)", cfg.Trim)
+	}
+
+	if cfg.Dir != "" && cfg.OutputFile != "" {
+		return errors.New("-outfile-mode cannot be combined with -cwd, each test runs in a directory of its own")
+	}
+	if cfg.Dir != "" && cfg.OutputDir {
+		return errors.New("-outdir-mode cannot be combined with -cwd, each test runs in a directory of its own")
+	}
+	if cfg.OutputDir && (cfg.OutputFile != "" || cfg.Hash || cfg.HashManifest != "" || cfg.Binary || cfg.ExpectedCmd != "" || cfg.Expr) {
+		return errors.New("-outdir-mode cannot be combined with -outfile-mode, -h, -hash-manifest, -binary, -expected-cmd or -expr")
+	}
+	if cfg.PerPlatform && cfg.HashManifest != "" {
+		return errors.New("-per-platform cannot be combined with -hash-manifest")
+	}
+
+	if cfg.PassThreshold < 0 || cfg.PassThreshold > 1 {
+		return fmt.Errorf("invalid -pass-threshold value %v: must be between 0 and 1", cfg.PassThreshold)
+	}
+	if cfg.CPULimit < 0 {
+		return fmt.Errorf("invalid -cpulimit value %v: must not be negative", cfg.CPULimit)
+	} else if cfg.CPULimit > 0 && !limitsSupported {
+		return errors.New("-cpulimit is not supported on this platform")
+	}
+	if !validSortedUnits[cfg.AssertSorted] {
+		return fmt.Errorf("invalid -assert-sorted value %q (expected lines or tokens)", cfg.AssertSorted)
+	} else if cfg.AssertSorted != "" && (cfg.Hash || cfg.OutputDir || cfg.Binary) {
+		return errors.New("-assert-sorted cannot be combined with -h, -outdir-mode or -binary")
+	}
+	if !validSeedArgs[cfg.SeedArg] {
+		return fmt.Errorf("invalid -seed-arg value %q (expected index or input)", cfg.SeedArg)
+	}
+	if cfg.StderrBuffer < 0 {
+		return errors.New("-stderr-buffer must not be negative")
+	}
+	if cfg.MemLimit < 0 || cfg.MemBudget < 0 {
+		return errors.New("-m and -mem-budget must not be negative")
+	} else if cfg.MemLimit > 0 && !limitsSupported {
+		return errors.New("-m is not supported on this platform")
+	} else if cfg.MemBudget > 0 && cfg.MemLimit == 0 {
+		return errors.New("-mem-budget needs a per-test memory limit from -m")
+	}
+	if cfg.Delay < 0 {
+		return fmt.Errorf("invalid -delay value %v: must not be negative", cfg.Delay)
+	}
+	if cfg.IdleTimeout < 0 {
+		return fmt.Errorf("invalid -idle-timeout value %v: must not be negative", cfg.IdleTimeout)
+	}
+
+	if cfg.Eps < 0 {
+		return fmt.Errorf("invalid -eps value %v: must not be negative", cfg.Eps)
+	}
+	for _, column := range cfg.Columns {
+		if column < 1 {
+			return fmt.Errorf("invalid -columns value %d: columns start at 1", column)
+		}
+	}
+	if cfg.Round != nil && *cfg.Round < 0 {
+		return fmt.Errorf("invalid -round value %d: must not be negative", *cfg.Round)
+	} else if cfg.Round != nil && cfg.Eps > 0 {
+		return errors.New("-round cannot be combined with -eps")
+	}
+	tokens := tokenComparison(cfg)
+	if cfg.LeadingZeros && !tokens {
+		return errors.New("-ignore-leading-zeros needs a numeric comparison: -numeric-equal, -int-float-equal, -eps, -round or -rows")
+	}
+	if cfg.Contains && tokens {
+		return errors.New("-contains cannot be combined with token comparisons")
+	}
+	if cfg.Multiset && (tokens || cfg.Contains) {
+		return errors.New("-multiset cannot be combined with -contains or token comparisons")
+	}
+	if cfg.MaxEdits != nil && *cfg.MaxEdits < 0 {
+		return fmt.Errorf("invalid -max-edits value %d: must not be negative", *cfg.MaxEdits)
+	} else if cfg.MaxEdits != nil && (tokens || cfg.Contains || cfg.Multiset || cfg.Binary) {
+		return errors.New("-max-edits cannot be combined with -contains, -multiset, -binary or token comparisons")
+	}
+	if cfg.StripANSI && (cfg.Hash || cfg.OutputDir || cfg.Binary) {
+		return errors.New("-strip-ansi cannot be combined with -h, -outdir-mode or -binary")
+	}
+	if cfg.ExtraLines && (cfg.Hash || cfg.OutputDir || cfg.Binary || cfg.Contains) {
+		return errors.New("-allow-extra-lines cannot be combined with -h, -outdir-mode, -binary or -contains")
+	}
+	// -binary is dropped with -h rather than rejected, see newRun
+	if cfg.Binary && !cfg.Hash && (tokens || cfg.WarnWhitespace || cfg.Expr || cfg.Contains || cfg.Multiset || cfg.CommentPrefix != "" || len(cfg.Columns) > 0 || cfg.Trim != TrimFull) {
+		return errors.New("-binary cannot be combined with token, whitespace, -expr, -contains, -multiset, -ignore-comments, -columns or -trim comparisons")
+	}
+
+	if cfg.ScoreByTime && cfg.BaselineFile == "" {
+		return errors.New("-score-by-time needs -baseline, the times of a previous run to score against")
+	}
+	if cfg.RerunFailed && cfg.StateFile == "" {
+		return errors.New("-rerun-failed needs a -state file")
+	}
+
+	if cfg.HashManifest != "" && (cfg.MatchExpected || cfg.OutTemplate != "" || cfg.ExpectedCmd != "") {
+		return errors.New("-hash-manifest cannot be combined with -match-expected, -out-template or -expected-cmd")
+	}
+	outTemplate := os.ExpandEnv(cfg.OutTemplate)
+	if outTemplate != "" && cfg.MatchExpected {
+		return errors.New("-out-template cannot be combined with -match-expected")
+	}
+	if cfg.ExpectedCmd != "" && (cfg.Generate || cfg.Update || cfg.Hash || cfg.Expr || cfg.MatchExpected || outTemplate != "" || cfg.RequireExpected) {
+		return errors.New("-expected-cmd cannot be combined with -g, -u, -h, -expr, -match-expected, -out-template or -require-expected")
+	}
+	if cfg.SelfBaseline != "" && (cfg.Generate || cfg.ExpectedCmd != "" || cfg.HashManifest != "" || cfg.Expr || cfg.MatchExpected || outTemplate != "" || cfg.RequireExpected) {
+		return errors.New("-self-baseline cannot be combined with -g, -expected-cmd, -hash-manifest, -expr, -match-expected, -out-template or -require-expected")
+	}
+	hash := cfg.Hash || cfg.HashManifest != ""
+	if cfg.Subtasks && (cfg.Generate || cfg.Update || hash || cfg.Binary || !detectsFormats(cfg)) {
+		return errors.New("-subtask-sections cannot be combined with -g, -u, -h, -binary, -hash-manifest, -out-template, -self-baseline, -match-expected, -outdir-mode, -expected-cmd or -expr")
+	}
+	if cfg.CasesFile != "" && (cfg.Generate || cfg.Update || hash || cfg.MatchExpected || cfg.SelfBaseline != "" || cfg.OutputDir) {
+		return errors.New("-cases cannot be combined with -g, -u, -h, -hash-manifest, -match-expected, -self-baseline or -outdir-mode")
+	}
+
+	if cfg.CasesFile == "" && cfg.Pattern == "" {
+		return errors.New("no glob pattern given")
+	}
+	// A "-" pattern runs one test read from stdin, which also rules out the prompts of -u
+	fromStdin := cfg.Pattern == "-"
+	if fromStdin && cfg.Repl {
+		return errors.New("-repl reads commands from stdin, it cannot be combined with a \"-\" pattern")
+	}
+	if fromStdin && (cfg.CasesFile != "" || cfg.Generate || cfg.Update || hash || cfg.MatchExpected || cfg.SelfBaseline != "" || cfg.OutputDir) {
+		return errors.New("a \"-\" pattern cannot be combined with -cases, -g, -u, -h, -hash-manifest, -match-expected, -self-baseline or -outdir-mode")
+	}
+	if (cfg.Expect != "" || cfg.ExpectFile != "") && !fromStdin {
+		return errors.New("-expect and -expect-file need the \"-\" pattern")
+	}
+	if cfg.Expect != "" && cfg.ExpectFile != "" {
+		return errors.New("-expect cannot be combined with -expect-file")
+	}
+	if fromStdin && cfg.Expect == "" && cfg.ExpectFile == "" && cfg.ExpectedCmd == "" {
+		return errors.New("a \"-\" pattern needs -expect, -expect-file or -expected-cmd")
+	}
+	return nil
+}
+
+// tokenComparison reports whether outputs are compared token by token, with numbers by value
+func tokenComparison(cfg Config) bool {
+	return cfg.NumericEqual || cfg.Eps > 0 || cfg.Rows || cfg.Round != nil || cfg.IntFloatEqual
+}
+
+// detectsFormats reports whether each test is compared in the format of the expected
+// file it has, so that .out and .hash files can be mixed in one suite. Any other source
+// of expected files rules that out.
+func detectsFormats(cfg Config) bool {
+	return cfg.HashManifest == "" && os.ExpandEnv(cfg.OutTemplate) == "" && cfg.SelfBaseline == "" && !cfg.MatchExpected &&
+		!cfg.OutputDir && cfg.ExpectedCmd == "" && !cfg.Expr
+}
+
+// run is the state of a single Run: what the configuration resolved to, the tests
+// found and everything counted while they run. Everything a test changes is guarded
+// by mu, only the program itself runs without it.
+type run struct {
+	cfg      Config
+	out      io.Writer
+	confirm  func(question string) bool
+	prompt   func(prompt string) (string, bool)
+	stdin    io.Reader
+	executor executor
+
+	programPath string
+	globPattern string
+	// Under GenWith, expected files are generated by a trusted reference program instead of the one under test
+	generatorPath        string
+	generatorInterpreter []string
+	generatorNote        string
+	opts                 execOptions
+	cmpOpts              compareOptions
+
+	baseline         resultsFile
+	matchTags        tagExpr
+	previousFailures map[string]bool
+	quarantined      map[string]bool
+	// Under HashManifest, the expected hashes of all tests come from a single file
+	manifest      *hashManifest
+	expectedExt   string
+	outTemplate   string
+	detectFormats bool
+
+	// inline holds the inline cases of a Cases manifest and the test read from stdin
+	inline map[string]inlineCase
+	// expectedFor maps each input to its expected file under MatchExpected
+	expectedFor map[string]string
+	// seedIndex numbers the tests found for -seed-arg index
+	seedIndex map[string]int
+	// names are the tests' names padded to nameWidth, along with their description
+	names     map[string]string
+	nameWidth int
+	// descriptionLines holds the inputs starting with an -inline-desc description line
+	descriptionLines map[string]bool
+	overhead         time.Duration
+
+	reporters []Reporter
+	stats     *runStats
+	resume    *checkpoint
+
+	mu                 sync.Mutex
+	results            []TestResult
+	totalTests         int
+	passedTests        int
+	generatedFiles     int
+	slowTests          int
+	updatedFiles       int
+	timedOutTests      int
+	notRun             int
+	totalExecutionTime time.Duration
+	outputs            sizeStats
+	// Under Repl the prompt shows what the last run of the program read and wrote, and what was expected of it
+	lastRun      execResult
+	lastExpected []string
+	// started is set once a test has run, for pause
+	started bool
+}
+
+// newRun resolves the program and the options of a validated configuration, and
+// loads the files shared by all tests: the baseline, state, quarantine list and
+// hash manifest
+func (r *Runner) newRun(cfg Config) (*run, error) {
+	rn := &run{cfg: cfg, out: r.Out, confirm: r.Confirm, prompt: r.Prompt, stdin: r.Stdin, executor: r.executor}
+	if rn.out == nil {
+		rn.out = os.Stdout
+	}
+	stdinReader := bufio.NewReader(os.Stdin)
+	if rn.confirm == nil {
+		rn.confirm = func(question string) bool {
+			return confirmOnStdin(rn.out, stdinReader, question)
+		}
+	}
+	if rn.prompt == nil {
+		rn.prompt = func(question string) (string, bool) {
+			return promptOnStdin(rn.out, stdinReader, question)
+		}
+	}
+	if rn.stdin == nil {
+		rn.stdin = os.Stdin
+	}
+	if rn.executor == nil {
+		rn.executor = processExecutor{}
+	}
+
+	if cfg.Perf {
+		if runtime.GOOS != "linux" {
+			Log.Warnf("-perf is only supported on Linux, ignoring it")
+			cfg.Perf = false
+		} else if _, err := exec.LookPath("perf"); err != nil {
+			Log.Warnf("-perf requires the perf tool in PATH, ignoring it: %v", err)
+			cfg.Perf = false
+		}
+	}
+
+	var err error
+	rn.programPath = resolveProgramPath(cfg.Program)
+	rn.globPattern = filepath.FromSlash(cfg.Pattern)
+	if cfg.Dir != "" {
+		if info, err := os.Stat(cfg.Dir); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("invalid -cwd: %s is not a directory", cfg.Dir)
+		}
+	}
+	// Relative program paths are meant relative to where harn was started, not to the program's working directory
+	ownDir := cfg.Dir != "" || cfg.OutputFile != "" || cfg.OutputDir
+	if ownDir && strings.ContainsAny(rn.programPath, "/"+string(filepath.Separator)) {
+		if rn.programPath, err = filepath.Abs(rn.programPath); err != nil {
+			return nil, fmt.Errorf("failed to resolve program path: %v", err)
+		}
+	}
+	if len(cfg.Interpreter) == 0 {
+		if cfg.Interpreter = detectInterpreter(rn.programPath); cfg.Interpreter != nil {
+			Log.Infof("Running %s with %s, detected from its extension (use -interpreter to override)", cfg.Program, strings.Join(cfg.Interpreter, " "))
+		}
+	}
+	if err := rn.executor.check(rn.programPath, cfg.Interpreter); err != nil {
+		return nil, fmt.Errorf("cannot run program: %v", err)
+	}
+
+	rn.generatorPath, rn.generatorInterpreter = rn.programPath, cfg.Interpreter
+	if cfg.PerPlatform && !cfg.Generate {
+		Log.Warnf("-per-platform only affects -g, platform-specific expected files are always preferred")
+	}
+	if cfg.GenWith != "" && !cfg.Generate {
+		Log.Warnf("-gen-with only affects -g, tests run %s as usual", cfg.Program)
+	} else if cfg.GenWith != "" {
+		rn.generatorPath, rn.generatorNote = resolveProgramPath(cfg.GenWith), " with "+cfg.GenWith
+		rn.generatorInterpreter = detectInterpreter(rn.generatorPath)
+		if ownDir && strings.ContainsAny(rn.generatorPath, "/"+string(filepath.Separator)) {
+			if rn.generatorPath, err = filepath.Abs(rn.generatorPath); err != nil {
+				return nil, fmt.Errorf("failed to resolve -gen-with path: %v", err)
+			}
+		}
+		if err := rn.executor.check(rn.generatorPath, rn.generatorInterpreter); err != nil {
+			return nil, fmt.Errorf("cannot run -gen-with program: %v", err)
+		}
+	}
+
+	if cfg.Binary && cfg.Hash {
+		Log.Warnf("-binary has no effect with -h, hashes already cover the raw output")
+		cfg.Binary = false
+	}
+	rn.cmpOpts = newCompareOptions(cfg)
+
+	if cfg.BaselineFile != "" {
+		if rn.baseline, err = readResults(cfg.BaselineFile); err != nil {
+			return nil, fmt.Errorf("failed to load baseline: %v", err)
+		}
+		Log.Infof("Loaded baseline %s with %d tests", cfg.BaselineFile, len(rn.baseline.Tests))
+	}
+	if cfg.Tag != "" {
+		if rn.matchTags, err = parseTagExpr(cfg.Tag); err != nil {
+			return nil, fmt.Errorf("invalid -tag expression %q: %v", cfg.Tag, err)
+		}
+	}
+	if rn.previousFailures, err = readFailures(cfg.StateFile); err != nil {
+		if cfg.RerunFailed {
+			return nil, fmt.Errorf("failed to read failing tests from %s: %v", cfg.StateFile, err)
+		}
+		Log.Warnf("Failed to read failing tests from %s: %v", cfg.StateFile, err)
+	}
+	rn.quarantined = make(map[string]bool)
+	if cfg.QuarantineFile != "" {
+		if rn.quarantined, err = readQuarantine(cfg.QuarantineFile, time.Now()); err != nil {
+			return nil, fmt.Errorf("failed to load quarantine list: %v", err)
+		}
+	}
+	if cfg.HashManifest != "" {
+		if rn.manifest, err = readHashManifest(cfg.HashManifest); err != nil {
+			return nil, fmt.Errorf("failed to load hash manifest: %v", err)
+		}
+		cfg.Hash = true
+	}
+
+	rn.expectedExt = ".out"
+	if cfg.Hash {
+		rn.expectedExt = ".hash"
+	} else if cfg.OutputDir {
+		rn.expectedExt = ".outdir"
+	}
+	// Environment variables in the template are expanded once, the placeholders for each test
+	rn.outTemplate = os.ExpandEnv(cfg.OutTemplate)
+	rn.detectFormats = detectsFormats(cfg)
+
+	successCodes := map[int]bool{0: true}
+	if len(cfg.OkCodes) > 0 {
+		successCodes = make(map[int]bool)
+		for _, code := range cfg.OkCodes {
+			successCodes[code] = true
+		}
+	}
+	rn.opts = execOptions{
+		inputSuffix:   cfg.StdinAppend,
+		timeout:       cfg.Timeout,
+		cpuLimit:      cfg.CPULimit,
+		idleTimeout:   cfg.IdleTimeout,
+		outputFile:    cfg.OutputFile,
+		outputDir:     cfg.OutputDir,
+		memLimit:      cfg.MemLimit,
+		hash:          cfg.Hash,
+		perf:          cfg.Perf,
+		interpreter:   cfg.Interpreter,
+		dir:           cfg.Dir,
+		slowStdin:     cfg.SlowStdin,
+		seed:          cfg.Seed,
+		okCodes:       successCodes,
+		pre:           cfg.Pre,
+		post:          cfg.Post,
+		captureStderr: cfg.NoStderr,
+		args:          cfg.ProgramArgs,
+		seedArg:       cfg.SeedArg,
+		stderrLimit:   cfg.StderrBuffer,
+	}
+	if cfg.StrictInput {
+		if _, err := readIOCounters(os.Getpid()); err != nil {
+			Log.Warnf("-strict-input has no effect: %v", err)
+		} else {
+			rn.opts.strictInput = true
+		}
+	}
+	if rn.opts.seed == 0 {
+		rn.opts.seed = time.Now().UnixNano()
+	}
+	rn.cfg = cfg
+	return rn, nil
+}
+
+// newCompareOptions turns the comparison flags of a validated configuration into
+// compareOptions, warning about the ones that have no effect
+func newCompareOptions(cfg Config) compareOptions {
+	cmpOpts := compareOptions{
+		trim:          cfg.Trim,
+		tokens:        tokenComparison(cfg),
+		rows:          cfg.Rows,
+		eps:           cfg.Eps,
+		delims:        cfg.Delims,
+		whitespace:    cfg.WarnWhitespace,
+		contains:      cfg.Contains,
+		multiset:      cfg.Multiset,
+		commentPrefix: cfg.CommentPrefix,
+		columns:       cfg.Columns,
+		extraLines:    cfg.ExtraLines,
+		binary:        cfg.Binary,
+	}
+	if cfg.Round != nil {
+		cmpOpts.rounded, cmpOpts.decimals = true, *cfg.Round
+	}
+	if cfg.IntFloatEqual {
+		// Numeric comparisons already compare numbers by value, whatever their spelling
+		if cfg.NumericEqual || cfg.Eps > 0 || cfg.Rows || cfg.Round != nil {
+			Log.Warnf("-int-float-equal has no effect with -numeric-equal, -eps, -round or -rows, which compare numbers by value")
+		} else {
+			cmpOpts.intFloat = true
+		}
+	}
+	if cfg.LeadingZeros {
+		if !cmpOpts.intFloat {
+			Log.Warnf("-ignore-leading-zeros has no effect without -int-float-equal, the other numeric comparisons already compare numbers by value")
+		}
+		cmpOpts.leadingZeros = true
+	}
+	if cfg.MaxEdits != nil {
+		cmpOpts.fuzzy, cmpOpts.maxEdits = true, *cfg.MaxEdits
+	}
+	if cfg.Delims != "" && !cmpOpts.tokens && !cfg.Multiset && len(cfg.Columns) == 0 && cfg.AssertSorted != SortedTokens {
+		Log.Warnf("-delim only affects token comparisons, -columns and -assert-sorted tokens, use it with -numeric-equal, -int-float-equal, -eps, -round, -rows, -multiset, -columns or -assert-sorted tokens")
+	}
+	return cmpOpts
+}
+
+// discover finds the tests of a run: the inputs matching the pattern, or found from the
+// expected files it matches under MatchExpected, then the inline cases of a Cases manifest
+// and the test read from stdin for a "-" pattern
+func (rn *run) discover() ([]string, error) {
+	cfg := rn.cfg
+	fromStdin := cfg.Pattern == "-"
+	var inputFiles []string
+	var err error
+	if cfg.Pattern != "" && !fromStdin {
+		if inputFiles, err = globTests(rn.globPattern, cfg.IncludeHidden); err != nil {
+			return nil, fmt.Errorf("error matching glob pattern %q: %v", rn.globPattern, err)
+		}
+	}
+	// With MatchExpected the glob matched the expected files, each test's input is found from them
+	rn.expectedFor = make(map[string]string)
+	if cfg.MatchExpected {
+		expectedFiles := inputFiles
+		inputFiles = nil
+		for _, expectedFile := range expectedFiles {
+			inputFile, err := inputForExpected(expectedFile, rn.expectedExt)
+			if err != nil {
+				Log.Warnf("Skipping %s: %v", expectedFile, err)
+				continue
+			}
+			inputFiles = append(inputFiles, inputFile)
+			rn.expectedFor[inputFile] = expectedFile
+		}
+	}
+	// The inline cases of a Cases manifest run after the tests found by the pattern
+	rn.inline = make(map[string]inlineCase)
+	if cfg.CasesFile != "" {
+		cases, err := readCases(cfg.CasesFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load cases: %v", err)
+		}
+		for _, c := range cases {
+			if !c.hasExpected && cfg.ExpectedCmd == "" {
+				return nil, fmt.Errorf("%s has no expect entry (or use -expected-cmd)", c.name)
+			}
+			inputFiles = append(inputFiles, c.name)
+			rn.inline[c.name] = c
+		}
+		Log.Infof("Loaded %d inline cases from %s", len(cases), cfg.CasesFile)
+	}
+	if fromStdin {
+		input, err := io.ReadAll(rn.stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the input from stdin: %v", err)
+		}
+		c := inlineCase{name: "stdin", input: string(input), expected: cfg.Expect, hasExpected: cfg.ExpectedCmd == ""}
+		if cfg.ExpectFile != "" {
+			if c.expected, err = readFile(cfg.ExpectFile); err != nil {
+				return nil, fmt.Errorf("failed to read the expected output: %v", err)
+			}
+		}
+		inputFiles = append(inputFiles, c.name)
+		rn.inline[c.name] = c
+	}
+	// Seeds of -seed-arg index number the tests found, so they don't change when only some are run
+	rn.seedIndex = make(map[string]int, len(inputFiles))
+	for i, inputFile := range inputFiles {
+		rn.seedIndex[inputFile] = i + 1
+	}
+	return inputFiles, nil
+}
+
+// selectTests keeps the tests that failed in the previous run under RerunFailed, and
+// those whose tags match under Tag. It reports false when there is nothing left to run.
+func (rn *run) selectTests(inputFiles []string) ([]string, bool) {
+	cfg := rn.cfg
+	if cfg.RerunFailed {
+		if len(rn.previousFailures) == 0 {
+			fmt.Fprintf(rn.out, "No failing tests recorded in %s, nothing to re-run\n", cfg.StateFile)
+			return nil, false
+		}
+		var failing []string
+		for _, inputFile := range inputFiles {
+			if rn.previousFailures[inputFile] {
+				failing = append(failing, inputFile)
+			}
+		}
+		fmt.Fprintf(rn.out, "Loaded %d failing tests from %s, %d of them match %q\n", len(rn.previousFailures), cfg.StateFile, len(failing), rn.globPattern)
+		if len(failing) == 0 {
+			return nil, false
+		}
+		inputFiles = failing
+	}
+	if rn.matchTags != nil {
+		var tagged []string
+		for _, inputFile := range inputFiles {
+			testBase, _ := rn.testFiles(inputFile)
+			tags, err := readTags(testBase)
+			if err != nil {
+				Log.Warnf("Failed to read tags of %s: %v", inputFile, err)
+			}
+			if rn.matchTags(tags) {
+				tagged = append(tagged, inputFile)
+			}
+		}
+		fmt.Fprintf(rn.out, "%d of %d tests match tag expression %q\n", len(tagged), len(inputFiles), cfg.Tag)
+		if len(tagged) == 0 {
+			return nil, false
+		}
+		inputFiles = tagged
+	}
+	return inputFiles, true
+}
+
+// testFiles names the expected file of a test and the base name of its other per-test files
+func (rn *run) testFiles(inputFile string) (testBase, outputFile string) {
+	if _, ok := rn.inline[inputFile]; ok {
+		return inputFile, inputFile
+	}
+	if rn.cfg.SelfBaseline != "" {
+		testBase = testBaseName(inputFile)
+		return testBase, snapshotFile(rn.cfg.SelfBaseline, testBase, rn.expectedExt)
+	}
+	if expectedFile, ok := rn.expectedFor[inputFile]; ok {
+		testBase, outputFile = strings.TrimSuffix(expectedFile, rn.expectedExt), expectedFile
+	} else if testBase = testBaseName(inputFile); rn.outTemplate != "" {
+		outputFile = renderOutputTemplate(rn.outTemplate, testBase, rn.expectedExt)
+	} else {
+		outputFile = testBase + rn.expectedExt
+	}
+	return testBase, platformExpected(outputFile, rn.cfg.PerPlatform && rn.cfg.Generate)
+}
+
+// checkExpected fails under RequireExpected when some tests have no expected output
+func (rn *run) checkExpected(inputFiles []string) error {
+	if !rn.cfg.RequireExpected || rn.cfg.Generate {
+		return nil
+	}
+	var missing []string
+	for _, inputFile := range inputFiles {
+		testBase, outputFile := rn.testFiles(inputFile)
+		if rn.manifest != nil {
+			if _, ok := rn.manifest.lookup(inputFile, testBase); !ok {
+				missing = append(missing, "hash of "+inputFile)
+			}
+		} else if _, ok := rn.inline[inputFile]; !ok && !expectedExists(outputFile) {
+			// A test may have its expected output in the other format
+			useHash, _ := detectExpectedFormat(testBase, rn.cfg.Hash)
+			shared := rn.cfg.Subtasks && expectedExists(platformExpected(subtaskFile(testBase, rn.expectedExt), false))
+			if (!rn.detectFormats || useHash == rn.cfg.Hash) && !shared {
+				missing = append(missing, outputFile)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%d expected file(s) missing:\n  %s", len(missing), strings.Join(missing, "\n  "))
+	}
+	return nil
+}
+
+// setUp announces the tests about to run and sets up what they share: the
+// memory budget, the CPUs programs are pinned to and the startup overhead
+func (rn *run) setUp(inputFiles []string) error {
+	cfg, out := rn.cfg, rn.out
+	Log.Infof("Running %s on %d input files matching %q (timeout: %v)", rn.programPath, len(inputFiles), rn.globPattern, cfg.Timeout)
+
+	inlineTests := 0
+	for _, inputFile := range inputFiles {
+		if _, ok := rn.inline[inputFile]; ok {
+			inlineTests++
+		}
+	}
+	if cfg.Pattern == "-" {
+		fmt.Fprintf(out, "Running 1 test read from stdin (timeout: %v)\n", cfg.Timeout)
+	} else if cfg.CasesFile == "" {
+		fmt.Fprintf(out, "Found %d input files matching pattern \"%s\" (timeout: %v)\n", len(inputFiles), rn.globPattern, cfg.Timeout)
+	} else if cfg.Pattern == "" {
+		fmt.Fprintf(out, "Found %d inline cases in %s (timeout: %v)\n", inlineTests, cfg.CasesFile, cfg.Timeout)
+	} else {
+		fmt.Fprintf(out, "Found %d input files matching pattern \"%s\" and %d inline cases in %s (timeout: %v)\n", len(inputFiles)-inlineTests, rn.globPattern, inlineTests, cfg.CasesFile, cfg.Timeout)
+	}
+	if cfg.Stats {
+		var inputs sizeStats
+		for _, inputFile := range inputFiles {
+			if c, ok := rn.inline[inputFile]; ok {
+				inputs.add(inputFile, int64(len(c.input)))
+			} else if info, err := os.Stat(inputFile); err == nil {
+				inputs.add(inputFile, info.Size())
+			}
+		}
+		fmt.Fprintf(out, "Input sizes: %s\n", inputs)
+	}
+
+	// Under MemBudget, no more tests run at once than the budget holds at their memory limit
+	if cfg.MemBudget > 0 {
+		workers := int(cfg.MemBudget / cfg.MemLimit)
+		if workers < 1 {
+			Log.Warnf("-mem-budget %s is below the -m limit %s, running tests one at a time", FormatMemory(cfg.MemBudget), FormatMemory(cfg.MemLimit))
+			workers = 1
+		}
+		if rn.cfg.Jobs > workers {
+			rn.cfg.Jobs = workers
+		}
+		running := rn.cfg.Jobs
+		if running < 1 {
+			running = 1
+		}
+		fmt.Fprintf(out, "Memory budget %s fits %d tests of %s, running %d at a time\n", FormatMemory(cfg.MemBudget), workers, FormatMemory(cfg.MemLimit), running)
+	}
+
+	if cfg.Pin {
+		if cpus, err := allowedCPUs(); err != nil {
+			Log.Warnf("-pin has no effect: %v", err)
+		} else {
+			// The last CPUs are used, the first ones tend to handle more interrupts
+			jobs := rn.cfg.Jobs
+			if jobs > 1 && jobs < len(cpus) {
+				cpus = cpus[len(cpus)-jobs:]
+			} else if jobs <= 1 {
+				cpus = cpus[len(cpus)-1:]
+			}
+			rn.opts.cpus = newCPUPool(cpus)
+			if len(cpus) == 1 {
+				fmt.Fprintf(out, "Pinning programs to CPU %d\n", cpus[0])
+			} else {
+				fmt.Fprintf(out, "Pinning programs to CPUs %s, one per running test\n", strings.Trim(fmt.Sprint(cpus), "[]"))
+			}
+		}
+	}
+
+	if len(cfg.Overhead) > 0 {
+		var err error
+		if rn.overhead, err = measureOverhead(cfg.Overhead, cfg.Dir); err != nil {
+			return fmt.Errorf("failed to measure startup overhead: %v", err)
+		}
+		fmt.Fprintf(out, "Startup overhead: %v (median of %d runs of %s), subtracted in adjusted times\n",
+			rn.overhead.Round(10*time.Microsecond), overheadRuns, strings.Join(cfg.Overhead, " "))
+	}
+	return nil
+}
+
+// sample picks a random sample of the tests under Sample
+func (rn *run) sample(inputFiles []string) []string {
+	if rn.cfg.Sample <= 0 || rn.cfg.Sample >= len(inputFiles) {
+		return inputFiles
+	}
+	sampleSeed := rn.cfg.Seed
+	if sampleSeed == 0 {
+		sampleSeed = time.Now().UnixNano()
+	}
+	inputFiles = sampleFiles(inputFiles, rn.cfg.Sample, sampleSeed)
+	fmt.Fprintf(rn.out, "Running a random sample of %d tests (seed: %d)\n", len(inputFiles), sampleSeed)
+	return inputFiles
+}
+
+// describe names the tests along with their description. Names are padded to the
+// same width when some of them carry a description.
+func (rn *run) describe(inputFiles []string) {
+	rn.names = make(map[string]string, len(inputFiles))
+	rn.descriptionLines = make(map[string]bool)
+	rn.nameWidth = 0
+	for _, inputFile := range inputFiles {
+		testBase, _ := rn.testFiles(inputFile)
+		rn.names[inputFile] = inputFile
+		description, fromInput := readDescription(testBase, inputFile, rn.cfg.InlineDesc)
+		rn.descriptionLines[inputFile] = fromInput
+		if description != "" {
+			rn.names[inputFile] = fmt.Sprintf("%s (%s)", inputFile, description)
+			rn.nameWidth = -1
+		}
+	}
+	if rn.nameWidth < 0 {
+		for _, name := range rn.names {
+			if len(name) > rn.nameWidth {
+				rn.nameWidth = len(name)
+			}
+		}
+	}
+}
+
+// openReports opens the -resume checkpoint and sets up the reporters of a run of tests.
+// Reporters see each result as its test finishes, and all of them after the run. The
+// terminal summary comes after the other built-in reporters, right at the end of the output.
+func (rn *run) openReports(tests int, terminal Reporter, extra []Reporter) error {
+	cfg, out := rn.cfg, rn.out
+	if cfg.ResumeFile != "" {
+		var err error
+		if rn.resume, err = openCheckpoint(cfg.ResumeFile); err != nil {
+			return fmt.Errorf("failed to open checkpoint: %v", err)
+		}
+		if len(rn.resume.done) > 0 {
+			fmt.Fprintf(out, "Resuming from checkpoint %s: %d test(s) already completed\n", cfg.ResumeFile, len(rn.resume.done))
+		}
+	}
+	rn.stats = &runStats{}
+	if cfg.GitHub {
+		rn.reporters = append(rn.reporters, githubReporter{out: out})
+	}
+	if cfg.Quickfix {
+		rn.reporters = append(rn.reporters, quickfixReporter{out: out})
+	}
+	if cfg.JSONFile != "" {
+		rn.reporters = append(rn.reporters, jsonReporter{filename: cfg.JSONFile})
+	}
+	if terminal == nil {
+		terminal = &terminalReporter{out: out, cfg: cfg, stats: rn.stats, baseline: rn.baseline, names: rn.names, nameWidth: rn.nameWidth, tests: tests}
+	} else {
+		// The live summary is drawn by the built-in terminal output
+		rn.cfg.LiveSummary = false
+	}
+	rn.reporters = append(rn.reporters, terminal)
+	rn.reporters = append(rn.reporters, extra...)
+	return nil
+}
+
+// schedule runs the tests, in parallel under Jobs or LiveSummary, with a prompt after
+// each failure under Repl, or else one after the other
+func (rn *run) schedule(inputFiles []string) {
+	cfg := &rn.cfg
+	rn.totalTests = len(inputFiles)
+	if cfg.Jobs > 1 && cfg.Update && !cfg.AssumeYes {
+		Log.Warnf("-u asks for confirmation, running tests one at a time (use -y to keep -j)")
+		cfg.Jobs = 1
+	}
+	if cfg.Repl && (cfg.Jobs > 1 || cfg.LiveSummary) {
+		Log.Warnf("-repl prompts after failures, running tests one at a time without -live-summary")
+		cfg.Jobs, cfg.LiveSummary = 1, false
+	}
+	if cfg.LiveSummary && cfg.Update && !cfg.AssumeYes {
+		Log.Warnf("-live-summary is ignored with -u, which asks for confirmation (use -y to keep it)")
+		cfg.LiveSummary = false
+	}
+	if cfg.Jobs > 1 && cfg.Delay > 0 {
+		Log.Warnf("-delay only applies to tests run one at a time, ignoring it with -j %d", cfg.Jobs)
+		cfg.Delay = 0
+	}
+	switch {
+	case cfg.Jobs > 1 || cfg.LiveSummary:
+		rn.runParallel(inputFiles)
+	case cfg.Repl:
+		rn.runRepl(inputFiles)
+	default:
+		for _, inputFile := range inputFiles {
+			rn.pause()
+			rn.runTest(inputFile)
+		}
+	}
+	if rn.notRun > 0 && cfg.MaxFailures > 0 {
+		fmt.Fprintf(rn.out, "%sStopped after %d failures; %d tests not run%s\n", Red, cfg.MaxFailures, rn.notRun, Reset)
+	}
+}
+
+// runParallel runs the tests on Jobs workers. Tests are reported one at a time under the
+// lock, so results of parallel tests never interleave and the live summary always stays
+// on the last line.
+func (rn *run) runParallel(inputFiles []string) {
+	tests := make(chan string)
+	workers := rn.cfg.Jobs
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for inputFile := range tests {
+				rn.pause()
+				rn.runTest(inputFile)
+			}
+		}()
+	}
+	for _, inputFile := range inputFiles {
+		tests <- inputFile
+	}
+	close(tests)
+	wg.Wait()
+	if rn.cfg.LiveSummary {
+		fmt.Fprint(rn.out, clearLine)
+	}
+	sortResults(rn.results, inputFiles)
+}
+
+// runRepl runs the tests one at a time under Repl, until the prompt says to stop
+func (rn *run) runRepl(inputFiles []string) {
+	for i, inputFile := range inputFiles {
+		rn.pause()
+		if !rn.replTest(inputFile) && i+1 < len(inputFiles) {
+			skipped := len(inputFiles) - i - 1
+			rn.notRun, rn.totalTests = rn.notRun+skipped, rn.totalTests-skipped
+			fmt.Fprintf(rn.out, "%sStopped at the -repl prompt; %d tests not run%s\n", Red, skipped, Reset)
+			return
+		}
+	}
+}
+
+// replTest runs a test under Repl and prompts should it fail, reporting whether to run the
+// remaining tests. A re-run replaces the failing result, rolling back what it counted.
+func (rn *run) replTest(inputFile string) bool {
+	finished := len(rn.results)
+	passedSoFar, timedOutSoFar, slowSoFar, generatedSoFar, updatedSoFar := rn.passedTests, rn.timedOutTests, rn.slowTests, rn.generatedFiles, rn.updatedFiles
+	elapsedSoFar, sizesSoFar := rn.totalExecutionTime, rn.outputs
+	run := func() (replCase, bool) {
+		rn.results = rn.results[:finished]
+		rn.passedTests, rn.timedOutTests, rn.slowTests, rn.generatedFiles, rn.updatedFiles = passedSoFar, timedOutSoFar, slowSoFar, generatedSoFar, updatedSoFar
+		rn.totalExecutionTime, rn.outputs = elapsedSoFar, sizesSoFar
+		rn.lastRun, rn.lastExpected = execResult{}, nil
+		rn.runTest(inputFile)
+		c := replCase{name: inputFile, input: rn.lastRun.input, expected: rn.lastExpected, actual: rn.lastRun.output}
+		if c.input == "" {
+			// The program failed, so the input is read again as it was before any -pre
+			inputOpts := rn.opts
+			inputOpts.descriptionLine = rn.descriptionLines[inputFile]
+			if testCase, ok := rn.inline[inputFile]; ok {
+				inputOpts.input = &testCase.input
+			}
+			c.input, _ = testInput(inputFile, inputOpts)
+		}
+		return c, countFailures(rn.results[finished:]) > 0
+	}
+	c, failed := run()
+	return !failed || repl(rn.out, rn.prompt, c, run)
+}
+
+// pause spaces out consecutive tests under Delay
+func (rn *run) pause() {
+	if rn.started && rn.cfg.Delay > 0 {
+		time.Sleep(rn.cfg.Delay)
+	}
+	rn.started = true
+}
+
+// finish saves what the run leaves for the next one and hands the results to the reporters
+func (rn *run) finish() Results {
+	cfg, out := rn.cfg, rn.out
+	// The run is complete, so the next one starts from scratch
+	if rn.resume != nil && rn.notRun == 0 {
+		if err := rn.resume.finish(); err != nil {
+			Log.Warnf("Failed to remove checkpoint %s: %v", cfg.ResumeFile, err)
+		}
+	}
+	if rn.manifest != nil {
+		if err := rn.manifest.write(); err != nil {
+			Log.Errorf("Failed to save hash manifest %s: %v", cfg.HashManifest, err)
+			fmt.Fprintf(out, "%sERR%s: failed to save hash manifest %s: %v\n", Red, Reset, cfg.HashManifest, err)
+		}
+	}
+	if !cfg.Generate && cfg.StateFile != "" {
+		if err := writeFailures(cfg.StateFile, rn.previousFailures, rn.results); err != nil {
+			Log.Warnf("Failed to save failing tests to %s: %v", cfg.StateFile, err)
+		}
+	}
+
+	failed := countFailures(rn.results) > 0
+	if cfg.PassThreshold > 0 && !cfg.Generate {
+		failed = !metPassThreshold(rn.results, cfg.PassThreshold)
+	}
+	runResults := Results{Tests: rn.results, TotalTime: rn.totalExecutionTime, NotRun: rn.notRun, Failed: failed}
+	*rn.stats = runStats{
+		total: rn.totalTests, passed: rn.passedTests, generated: rn.generatedFiles, updated: rn.updatedFiles,
+		slow: rn.slowTests, timedOut: rn.timedOutTests, overhead: rn.overhead, outputs: rn.outputs,
+	}
+	for _, reporter := range rn.reporters {
+		if err := reporter.RunDone(runResults); err != nil {
+			Log.Errorf("Failed to report results: %v", err)
+		}
+	}
+	return runResults
+}
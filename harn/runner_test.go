@@ -0,0 +1,1087 @@
+package harn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeExecutor stands in for the program under test, it sums the numbers of each
+// input unless the input asks it to misbehave. It takes a few milliseconds
+// depending on the sum, so that parallel tests finish out of order.
+type fakeExecutor struct{}
+
+func (fakeExecutor) check(programPath string, interpreter []string) error {
+	return nil
+}
+
+func (fakeExecutor) execute(programPath, inputFile string, opts execOptions) (execResult, error) {
+	input, err := testInput(inputFile, opts)
+	if err != nil {
+		return execResult{}, err
+	}
+	switch strings.TrimSpace(input) {
+	case "hang":
+		return execResult{time: opts.timeout, hangAfterRead: true}, context.DeadlineExceeded
+	case "crash":
+		return execResult{time: time.Millisecond}, &exitCodeError{code: 1}
+	case "slow":
+		return execResult{input: input, output: "0\n", time: 2 * time.Second}, nil
+	case "noisy":
+		return execResult{input: input, output: "0\n", time: time.Millisecond, stderr: "debug\n"}, nil
+	case "args":
+		// Echoes the program's arguments, for -seed-arg and --
+		args, err := programArgs(input, opts)
+		if err != nil {
+			return execResult{}, err
+		}
+		return execResult{input: input, output: strings.Join(args, " ") + "\n", time: time.Millisecond}, nil
+	case "random":
+		return execResult{input: input, output: strconv.FormatInt(time.Now().UnixNano(), 10) + "\n", time: time.Millisecond}, nil
+	}
+	sum := 0
+	for _, field := range strings.Fields(input) {
+		n, _ := strconv.Atoi(field)
+		sum += n
+	}
+	time.Sleep(time.Duration(sum%5) * time.Millisecond)
+	output := strconv.Itoa(sum) + "\n"
+	if opts.hash {
+		hash := sha256.Sum256([]byte(output))
+		output = hex.EncodeToString(hash[:])
+	}
+	return execResult{input: input, output: output, time: time.Millisecond}, nil
+}
+
+// writeTests creates a test directory holding name.in and name.out for every entry
+func writeTests(t *testing.T, tests map[string][2]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, files := range tests {
+		if err := os.WriteFile(filepath.Join(dir, name+".in"), []byte(files[0]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".out"), []byte(files[1]), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// runFake runs the tests in dir with the fake executor and returns the verdict of each test
+func runFake(t *testing.T, dir string, cfg Config) (Results, map[string]string) {
+	t.Helper()
+	cfg.Program = "fake"
+	cfg.Pattern = filepath.Join(dir, "*.in")
+	var out bytes.Buffer
+	results, err := (&Runner{Out: &out, executor: fakeExecutor{}}).Run(cfg)
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	verdicts := make(map[string]string)
+	for _, result := range results.Tests {
+		verdicts[strings.TrimSuffix(filepath.Base(result.Name), ".in")] = result.Verdict
+	}
+	return results, verdicts
+}
+
+func TestRunVerdicts(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"ac":    {"1 2", "3"},
+		"wa":    {"2 2", "5"},
+		"tle":   {"hang", "1"},
+		"re":    {"crash", "1"},
+		"slow":  {"slow", "0"},
+		"float": {"1 2", "3.0"},
+	})
+	results, verdicts := runFake(t, dir, Config{SoftLimit: time.Second})
+	want := map[string]string{
+		"ac":    VerdictAC,
+		"wa":    VerdictWA,
+		"tle":   VerdictTLE,
+		"re":    VerdictRE,
+		"slow":  VerdictSlow,
+		"float": VerdictWA,
+	}
+	for name, verdict := range want {
+		if verdicts[name] != verdict {
+			t.Errorf("%s: got verdict %s, want %s", name, verdicts[name], verdict)
+		}
+	}
+	if !results.Failed {
+		t.Errorf("run with failing tests is not marked as failed")
+	}
+}
+
+func TestRunComparisonModes(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"float": {"1 2", "3.0"},
+		"close": {"1 2", "3.0001"},
+	})
+	tests := []struct {
+		name string
+		cfg  Config
+		want map[string]string
+	}{
+		{"exact", Config{}, map[string]string{"float": VerdictWA, "close": VerdictWA}},
+		{"numeric-equal", Config{NumericEqual: true}, map[string]string{"float": VerdictAC, "close": VerdictWA}},
+		{"eps", Config{Eps: 1e-3}, map[string]string{"float": VerdictAC, "close": VerdictAC}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, verdicts := runFake(t, dir, tt.cfg)
+			for name, verdict := range tt.want {
+				if verdicts[name] != verdict {
+					t.Errorf("%s: got verdict %s, want %s", name, verdicts[name], verdict)
+				}
+			}
+		})
+	}
+}
+
+func TestRunExpectedCmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the reference command is a POSIX shell script")
+	}
+	// The expected files are ignored, the reference multiplies where the fake program adds
+	dir := writeTests(t, map[string][2]string{
+		"1": {"1 2", "3"},
+		"2": {"2 2", "3"},
+	})
+	_, verdicts := runFake(t, dir, Config{ExpectedCmd: "read a b; echo $((a * b))"})
+	if verdicts["1"] != VerdictWA || verdicts["2"] != VerdictAC {
+		t.Errorf("got verdicts %v, want 1: WA and 2: AC", verdicts)
+	}
+
+	_, verdicts = runFake(t, dir, Config{ExpectedCmd: "test -f {input} && echo 4"})
+	if verdicts["1"] != VerdictWA || verdicts["2"] != VerdictAC {
+		t.Errorf("with {input}: got verdicts %v, want 1: WA and 2: AC", verdicts)
+	}
+}
+
+func TestRunOutputFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the program is a POSIX shell script")
+	}
+	dir := writeTests(t, map[string][2]string{
+		"1": {"1 2", "3"},
+		"2": {"2 2", "5"},
+	})
+	program := filepath.Join(t.TempDir(), "sum.sh")
+	script := "#!/bin/sh\nread a b\necho ignored\necho $((a + b)) > output.txt\n"
+	if err := os.WriteFile(program, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	results, err := (&Runner{Out: &bytes.Buffer{}}).Run(Config{
+		Program:    program,
+		Pattern:    filepath.Join(dir, "*.in"),
+		OutputFile: "output.txt",
+	})
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	verdicts := make(map[string]string)
+	for _, result := range results.Tests {
+		verdicts[strings.TrimSuffix(filepath.Base(result.Name), ".in")] = result.Verdict
+	}
+	if verdicts["1"] != VerdictAC || verdicts["2"] != VerdictWA {
+		t.Errorf("got verdicts %v, want 1: AC and 2: WA", verdicts)
+	}
+}
+
+func TestRunNoStderr(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"quiet": {"1 2", "3"},
+		"noisy": {"noisy", "0"},
+	})
+	if _, verdicts := runFake(t, dir, Config{}); verdicts["noisy"] != VerdictAC {
+		t.Errorf("without -no-stderr: got verdict %s for a correct output, want AC", verdicts["noisy"])
+	}
+	_, verdicts := runFake(t, dir, Config{NoStderr: true})
+	if verdicts["quiet"] != VerdictAC || verdicts["noisy"] != VerdictStderr {
+		t.Errorf("got verdicts %v, want quiet: AC and noisy: STDERR", verdicts)
+	}
+}
+
+func TestRunRerunFailed(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"ac": {"1 2", "3"},
+		"wa": {"2 2", "5"},
+	})
+	stateFile := filepath.Join(dir, "state")
+	if _, verdicts := runFake(t, dir, Config{StateFile: stateFile}); len(verdicts) != 2 {
+		t.Fatalf("first run: got verdicts %v, want both tests", verdicts)
+	}
+	_, verdicts := runFake(t, dir, Config{StateFile: stateFile, RerunFailed: true})
+	if len(verdicts) != 1 || verdicts["wa"] != VerdictWA {
+		t.Errorf("got verdicts %v, want only wa: WA", verdicts)
+	}
+}
+
+func TestRunTag(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"1.large":       {"1 2", "3"},
+		"2":             {"2 2", "4"},
+		"3.large.flaky": {"2 2", "5"},
+	})
+	if err := os.WriteFile(filepath.Join(dir, "2.tags"), []byte("large\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, verdicts := runFake(t, dir, Config{Tag: "large && !flaky"})
+	if len(verdicts) != 2 || verdicts["1.large"] != VerdictAC || verdicts["2"] != VerdictAC {
+		t.Errorf("got verdicts %v, want 1.large and 2: AC", verdicts)
+	}
+}
+
+func TestRunSubtasks(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"5.own": {"1 1", "2"},
+	})
+	files := map[string]string{
+		"12.small.in": "1 2", "12.large.in": "100 200", "12.medium.in": "1", "12.wrong.in": "1",
+		"12.out": "@@ subtask small\n3\n@@ subtask large\n300\n@@ subtask wrong\n2\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, verdicts := runFake(t, dir, Config{Subtasks: true, RequireExpected: true})
+	want := map[string]string{"5.own": VerdictAC, "12.small": VerdictAC, "12.large": VerdictAC, "12.medium": VerdictErr, "12.wrong": VerdictWA}
+	for name, verdict := range want {
+		if verdicts[name] != verdict {
+			t.Errorf("%s: got verdict %s, want %s", name, verdicts[name], verdict)
+		}
+	}
+}
+
+func TestRunDelay(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"1": {"1 2", "3"},
+		"2": {"2 2", "4"},
+		"3": {"2 3", "5"},
+	})
+	start := time.Now()
+	runFake(t, dir, Config{Delay: 20 * time.Millisecond})
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("3 tests took %v, want at least 2 delays of 20ms", elapsed)
+	}
+}
+
+func TestRunSelfBaseline(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"1": {"1 2", "wrong"},
+	})
+	baseline := filepath.Join(dir, "baseline")
+	if _, verdicts := runFake(t, dir, Config{SelfBaseline: baseline}); verdicts["1"] != VerdictGen {
+		t.Fatalf("first run: got verdict %s, want GEN", verdicts["1"])
+	}
+	if _, verdicts := runFake(t, dir, Config{SelfBaseline: baseline}); verdicts["1"] != VerdictAC {
+		t.Errorf("second run: got verdict %s, want AC against the recorded output", verdicts["1"])
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1.in"), []byte("2 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, verdicts := runFake(t, dir, Config{SelfBaseline: baseline}); verdicts["1"] != VerdictWA {
+		t.Errorf("changed output: got verdict %s, want WA", verdicts["1"])
+	}
+}
+
+func TestRunCheckDeterminism(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"ac":     {"1 2", "3"},
+		"random": {"random", "0"},
+	})
+	_, verdicts := runFake(t, dir, Config{CheckDeterminism: true})
+	if verdicts["ac"] != VerdictAC || verdicts["random"] != VerdictNondeterministic {
+		t.Errorf("got verdicts %v, want ac: AC and random: NONDETERMINISTIC", verdicts)
+	}
+}
+
+func TestRunTrimModes(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"padded": {"1 2", "\n  3\n\n"},
+	})
+	tests := []struct {
+		trim, want string
+	}{
+		{TrimFull, VerdictAC},
+		{TrimBlankLines, VerdictWA},
+		{TrimNone, VerdictWA},
+	}
+	for _, tt := range tests {
+		_, verdicts := runFake(t, dir, Config{Trim: tt.trim})
+		if verdicts["padded"] != tt.want {
+			t.Errorf("-trim %s: got verdict %s, want %s", tt.trim, verdicts["padded"], tt.want)
+		}
+	}
+}
+
+func TestRunPassThreshold(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"1": {"1 2", "3"},
+		"2": {"2 2", "4"},
+		"3": {"2 2", "5"},
+	})
+	if results, _ := runFake(t, dir, Config{PassThreshold: 0.6}); results.Failed {
+		t.Errorf("2/3 passed with a 0.6 threshold, but the run failed")
+	}
+	if results, _ := runFake(t, dir, Config{PassThreshold: 0.7}); !results.Failed {
+		t.Errorf("2/3 passed with a 0.7 threshold, but the run succeeded")
+	}
+}
+
+func TestRunSeedArg(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"1": {"args", "-n 5 1"},
+		"2": {"args", "-n 5 2"},
+		"3": {"args", "-n 5 args"},
+	})
+	want := map[string]string{"1": VerdictAC, "2": VerdictAC, "3": VerdictWA}
+	// Indexes number the tests found, whichever of them a sample runs
+	for _, sample := range []int{0, 2} {
+		_, verdicts := runFake(t, dir, Config{SeedArg: SeedArgIndex, ProgramArgs: []string{"-n", "5"}, Sample: sample, Seed: 1})
+		for name, verdict := range verdicts {
+			if verdict != want[name] {
+				t.Errorf("%s (sample %d): got verdict %s, want %s", name, sample, verdict, want[name])
+			}
+		}
+	}
+	_, verdicts := runFake(t, dir, Config{SeedArg: SeedArgInput, ProgramArgs: []string{"-n", "5"}})
+	if verdicts["3"] != VerdictAC {
+		t.Errorf("3: got verdict %s under -seed-arg input, want %s", verdicts["3"], VerdictAC)
+	}
+}
+
+func TestRunMixedFormats(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"text": {"1 2", "3"},
+		"both": {"1 1", "2"},
+	})
+	for name, output := range map[string]string{"hashed": "4\n", "both": "2\n"} {
+		hash := sha256.Sum256([]byte(output))
+		if err := os.WriteFile(filepath.Join(dir, name+".hash"), []byte(hex.EncodeToString(hash[:])), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "hashed.in"), []byte("2 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Each test is compared against the expected file it has, with or without -h
+	for _, hash := range []bool{false, true} {
+		_, verdicts := runFake(t, dir, Config{Hash: hash, RequireExpected: true})
+		for _, name := range []string{"text", "hashed", "both"} {
+			if verdicts[name] != VerdictAC {
+				t.Errorf("%s (hash %v): got verdict %s, want %s", name, hash, verdicts[name], VerdictAC)
+			}
+		}
+	}
+}
+
+func TestRunTagged(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"ac": {"1 2", "3"},
+		"wa": {"2 2", "5"},
+	})
+	var out bytes.Buffer
+	if _, err := (&Runner{Out: &out, executor: fakeExecutor{}}).Run(Config{
+		Program: "fake",
+		Pattern: filepath.Join(dir, "*.in"),
+		Tagged:  true,
+	}); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	for _, want := range []string{fmt.Sprintf("%-*s %s", tagWidth, VerdictAC, Yellow), fmt.Sprintf("%-*s %s", tagWidth, VerdictWA, Yellow)} {
+		if !strings.Contains(out.String(), "\n"+want) {
+			t.Errorf("output has no line starting with %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRunInvalidConfig(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{"1": {"1 2", "3"}})
+	for name, cfg := range map[string]Config{
+		"trim":           {Trim: "sideways"},
+		"eps":            {Eps: -1},
+		"pass-threshold": {PassThreshold: 2},
+		"binary":         {Binary: true, NumericEqual: true},
+		"expected-cmd":   {ExpectedCmd: "cat", Generate: true},
+		"rerun-failed":   {RerunFailed: true},
+		"tag":            {Tag: "large &&"},
+		"delay":          {Delay: -time.Second},
+		"self-baseline":  {SelfBaseline: "baseline", Generate: true},
+		"outdir-mode":    {OutputDir: true, Hash: true},
+		"cases":          {CasesFile: "smoke.cases", Generate: true},
+		"mem-budget":     {MemBudget: 1 << 30},
+		"expect":         {Expect: "3"},
+		"assert-sorted":  {AssertSorted: "rows"},
+		"leading-zeros":  {LeadingZeros: true},
+		"score-by-time":  {ScoreByTime: true},
+		"subtasks":       {Subtasks: true, Generate: true},
+		"extra-lines":    {ExtraLines: true, Contains: true},
+	} {
+		cfg.Program = "fake"
+		cfg.Pattern = filepath.Join(dir, "*.in")
+		if _, err := (&Runner{Out: &bytes.Buffer{}, executor: fakeExecutor{}}).Run(cfg); err == nil {
+			t.Errorf("%s: Run() accepted an invalid configuration", name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{Pattern: "*.in"}, ""},
+		{Config{CasesFile: "smoke.cases"}, ""},
+		{Config{Pattern: "-", Expect: "3"}, ""},
+		{Config{}, "no glob pattern"},
+		{Config{Pattern: "*.in", DiffGranularity: "page"}, "-diff-granularity"},
+		{Config{Pattern: "*.in", TimeColors: [2]float64{0.9, 0.5}}, "-time-colors"},
+		{Config{Pattern: "*.in", Dir: "work", OutputFile: "out.txt"}, "-outfile-mode cannot be combined with -cwd"},
+		{Config{Pattern: "*.in", Round: new(int), Eps: 0.1}, "-round cannot be combined with -eps"},
+		{Config{Pattern: "*.in", Contains: true, IntFloatEqual: true}, "-contains cannot be combined"},
+		// -h turns -binary off instead of rejecting its combinations
+		{Config{Pattern: "*.in", Binary: true, Hash: true, WarnWhitespace: true}, ""},
+		{Config{Pattern: "*.in", HashManifest: "tests.sha256", CasesFile: "smoke.cases"}, "-cases cannot be combined"},
+		{Config{Pattern: "*.in", OutTemplate: "golden/{name}.{ext}", Subtasks: true}, "-subtask-sections cannot be combined"},
+		{Config{Pattern: "-", Repl: true, Expect: "3"}, "-repl reads commands from stdin"},
+		{Config{Pattern: "-"}, "needs -expect"},
+	} {
+		err := validate(withDefaults(tt.cfg))
+		if tt.want == "" && err != nil {
+			t.Errorf("validate(%+v) = %v, want no error", tt.cfg, err)
+		} else if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("validate(%+v) = %v, want an error about %q", tt.cfg, err, tt.want)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{"1": {"1 2", "3"}, "2": {"2 2", "4"}})
+	manifest := filepath.Join(dir, "smoke.cases")
+	if err := os.WriteFile(manifest, []byte("case small\ninput 1 1\nexpect 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		cfg   Config
+		stdin string
+		want  []string
+	}{
+		{Config{Pattern: filepath.Join(dir, "*.in")}, "", []string{filepath.Join(dir, "1.in"), filepath.Join(dir, "2.in")}},
+		{Config{Pattern: filepath.Join(dir, "2.out"), MatchExpected: true}, "", []string{filepath.Join(dir, "2.in")}},
+		{Config{CasesFile: manifest}, "", []string{manifest + ":small"}},
+		{Config{Pattern: filepath.Join(dir, "1.in"), CasesFile: manifest}, "", []string{filepath.Join(dir, "1.in"), manifest + ":small"}},
+		{Config{Pattern: "-", Expect: "3"}, "1 2\n", []string{"stdin"}},
+	} {
+		cfg := withDefaults(tt.cfg)
+		cfg.Program = "fake"
+		rn, err := (&Runner{Out: &bytes.Buffer{}, Stdin: strings.NewReader(tt.stdin), executor: fakeExecutor{}}).newRun(cfg)
+		if err != nil {
+			t.Fatalf("newRun(%+v) failed: %v", tt.cfg, err)
+		}
+		got, err := rn.discover()
+		if err != nil {
+			t.Fatalf("discover(%+v) failed: %v", tt.cfg, err)
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("discover(%+v) = %q, want %q", tt.cfg, got, tt.want)
+		}
+		// Seeds of -seed-arg index number the tests in the order they were found
+		for i, inputFile := range got {
+			if rn.seedIndex[inputFile] != i+1 {
+				t.Errorf("discover(%+v): seed index of %s = %d, want %d", tt.cfg, inputFile, rn.seedIndex[inputFile], i+1)
+			}
+		}
+	}
+}
+
+func TestRunJudge(t *testing.T) {
+	for _, tt := range []struct {
+		expected []string
+		actual   string
+		verdict  string
+		want     string
+	}{
+		{[]string{"3"}, "3", VerdictAC, "AC" + Reset + " [1ms]: Output matches expected result\n"},
+		{[]string{"4", "3"}, "3", VerdictAC, "(alternate 1.out.2)"},
+		{[]string{"4"}, "3", VerdictWA, "WA" + Reset + " [1ms]: Output doesn't match\n === Diff:\n"},
+	} {
+		rn := &run{cfg: withDefaults(Config{}), cmpOpts: compareOptions{trim: TrimFull}}
+		test := &test{inputFile: "1.in", outputFile: "1.out", cmpOpts: rn.cmpOpts}
+		files := []string{"1.out", "1.out.2"}[:len(tt.expected)]
+		rn.judge(test, execResult{output: tt.actual, time: time.Millisecond}, files, tt.expected, "1ms")
+		if len(rn.results) != 1 || rn.results[0].Verdict != tt.verdict {
+			t.Errorf("judge(%q, %q) recorded %+v, want one %s", tt.expected, tt.actual, rn.results, tt.verdict)
+		}
+		if report := test.line.String() + test.details.String(); !strings.Contains(report, tt.want) {
+			t.Errorf("judge(%q, %q) reported %q, want %q in it", tt.expected, tt.actual, report, tt.want)
+		}
+	}
+}
+
+func TestRunMatchExpected(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"odd.txt": "1 2", "odd.out": "3",
+		"usual.in": "2 2", "usual.out": "4", "usual.weight": "2",
+		"orphan.out": "1",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	results, err := (&Runner{Out: &out, executor: fakeExecutor{}}).Run(Config{
+		Program:       "fake",
+		Pattern:       filepath.Join(dir, "*.out"),
+		MatchExpected: true,
+	})
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if len(results.Tests) != 2 {
+		t.Fatalf("got %d tests, want 2 (orphan.out has no input)", len(results.Tests))
+	}
+	for _, result := range results.Tests {
+		if result.Verdict != VerdictAC {
+			t.Errorf("%s: got verdict %s, want AC", result.Name, result.Verdict)
+		}
+	}
+}
+
+func TestRunParallelOutput(t *testing.T) {
+	tests := make(map[string][2]string)
+	want := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("%03d", i)
+		if i%3 == 0 {
+			tests[name], want[name] = [2]string{fmt.Sprintf("%d 1", i), "wrong"}, VerdictWA
+		} else {
+			tests[name], want[name] = [2]string{fmt.Sprintf("%d 1", i), strconv.Itoa(i + 1)}, VerdictAC
+		}
+	}
+	dir := writeTests(t, tests)
+
+	var out bytes.Buffer
+	results, err := (&Runner{Out: &out, executor: fakeExecutor{}}).Run(Config{
+		Program: "fake",
+		Pattern: filepath.Join(dir, "*.in"),
+		Jobs:    8,
+	})
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+
+	// Every test has a single, complete result line with balanced colors
+	resultLine := regexp.MustCompile(`^` + regexp.QuoteMeta(Yellow) + `(\S+) *` + regexp.QuoteMeta(Reset) +
+		` - \x1b\[\d+m(AC|WA)` + regexp.QuoteMeta(Reset) + ` \[\x1b\[\d+m\S+` + regexp.QuoteMeta(Reset) + `\]: `)
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if !strings.HasPrefix(line, Yellow+dir) {
+			continue
+		}
+		match := resultLine.FindStringSubmatch(line)
+		if match == nil {
+			t.Errorf("garbled result line %q", line)
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(match[1]), ".in")
+		if seen[name] {
+			t.Errorf("%s is reported twice", name)
+		}
+		seen[name] = true
+		if match[2] != want[name] {
+			t.Errorf("%s: printed verdict %s, want %s", name, match[2], want[name])
+		}
+		if strings.Count(line, "\x1b[") != 2*strings.Count(line, Reset) {
+			t.Errorf("unbalanced colors in %q", line)
+		}
+	}
+	if len(seen) != len(tests) {
+		t.Errorf("found %d result lines, want %d", len(seen), len(tests))
+	}
+
+	// Results are recorded in input order, whatever order the tests finished in
+	if len(results.Tests) != len(tests) {
+		t.Fatalf("got %d results, want %d", len(results.Tests), len(tests))
+	}
+	for i, result := range results.Tests {
+		if name := strings.TrimSuffix(filepath.Base(result.Name), ".in"); name != fmt.Sprintf("%03d", i) {
+			t.Errorf("result %d is %s", i, name)
+		}
+	}
+}
+
+func TestRunLiveSummary(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"1": {"1 2", "3"},
+		"2": {"2 2", "5"},
+		"3": {"3 2", "5"},
+	})
+	for _, jobs := range []int{1, 3} {
+		var out bytes.Buffer
+		_, err := (&Runner{Out: &out, executor: fakeExecutor{}}).Run(Config{
+			Program:     "fake",
+			Pattern:     filepath.Join(dir, "*.in"),
+			Jobs:        jobs,
+			LiveSummary: true,
+		})
+		if err != nil {
+			t.Fatalf("Run() failed: %v", err)
+		}
+
+		// Each status line is erased by the next report, the counts only go up
+		var statuses []string
+		for _, chunk := range strings.Split(out.String(), clearLine)[1:] {
+			lines := strings.Split(chunk, "\n")
+			statuses = append(statuses, lines[len(lines)-1])
+		}
+		if len(statuses) != 4 || statuses[3] != "" {
+			t.Fatalf("-j %d: got status lines %q, want 3 then a cleared line", jobs, statuses)
+		}
+		for i, status := range statuses[:3] {
+			if want := fmt.Sprintf("/%d passed so far", i+1); !strings.Contains(status, want) {
+				t.Errorf("-j %d: status %d is %q, want it to show %q", jobs, i, status, want)
+			}
+		}
+		if !strings.Contains(statuses[2], "2/3 passed so far") {
+			t.Errorf("-j %d: final status is %q, want 2/3 passed", jobs, statuses[2])
+		}
+	}
+}
+
+// recordingReporter remembers the results it receives
+type recordingReporter struct {
+	done []string
+	run  Results
+}
+
+func (r *recordingReporter) TestDone(result TestResult) {
+	r.done = append(r.done, result.Verdict)
+}
+
+func (r *recordingReporter) RunDone(results Results) error {
+	r.run = results
+	return nil
+}
+
+func TestRunReporters(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"1": {"1 2", "3"},
+		"2": {"2 2", "5"},
+	})
+	reporters := []*recordingReporter{{}, {}}
+	_, err := (&Runner{Out: &bytes.Buffer{}, executor: fakeExecutor{}, Reporters: []Reporter{reporters[0], reporters[1]}}).Run(Config{
+		Program: "fake",
+		Pattern: filepath.Join(dir, "*.in"),
+	})
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	for i, reporter := range reporters {
+		if strings.Join(reporter.done, " ") != "AC WA" {
+			t.Errorf("reporter %d: got TestDone verdicts %v, want AC and WA", i, reporter.done)
+		}
+		if len(reporter.run.Tests) != 2 || !reporter.run.Failed {
+			t.Errorf("reporter %d: got RunDone results %+v, want 2 tests and a failed run", i, reporter.run)
+		}
+	}
+}
+
+func TestRunTerminal(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"1": {"1 2", "3"},
+		"2": {"2 2", "5"},
+	})
+	var out bytes.Buffer
+	terminal := &recordingReporter{}
+	results, err := (&Runner{Out: &out, executor: fakeExecutor{}, Terminal: terminal}).Run(Config{
+		Program: "fake",
+		Pattern: filepath.Join(dir, "*.in"),
+	})
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if strings.Contains(out.String(), "AC") || strings.Contains(out.String(), "Test Results") {
+		t.Errorf("Run() printed %q, want the result lines and summary replaced", out.String())
+	}
+	if strings.Join(terminal.done, " ") != "AC WA" || len(terminal.run.Tests) != 2 {
+		t.Errorf("replacement terminal got TestDone verdicts %v and RunDone results %+v, want AC and WA", terminal.done, terminal.run)
+	}
+	if details := results.Tests[1].Details; !strings.Contains(details, " === Diff:\n") {
+		t.Errorf("WA details = %q, want the diff", details)
+	}
+}
+
+func TestOutputActivityWatch(t *testing.T) {
+	activity := newOutputActivity()
+	killed := make(chan time.Time, 1)
+	start := time.Now()
+	go activity.watch(50*time.Millisecond, make(chan struct{}), func() { killed <- time.Now() })
+	// Steady output keeps the program alive past the idle timeout
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		activity.Write([]byte("."))
+	}
+	select {
+	case at := <-killed:
+		t.Fatalf("killed after %v despite steady output", at.Sub(start))
+	default:
+	}
+	select {
+	case <-killed:
+	case <-time.After(time.Second):
+		t.Fatal("not killed once the output stopped")
+	}
+}
+
+func TestSizeStats(t *testing.T) {
+	var stats sizeStats
+	if got := stats.String(); got != "none" {
+		t.Errorf("empty sizeStats = %q, want none", got)
+	}
+	stats.add("a.in", 512)
+	stats.add("big.in", 3<<20)
+	stats.add("c.in", 1536)
+	if want := "3MB total, 1MB average, largest 3MB (big.in)"; stats.String() != want {
+		t.Errorf("sizeStats = %q, want %q", stats.String(), want)
+	}
+}
+
+func TestDirectorySummary(t *testing.T) {
+	tests := []TestResult{
+		{Name: filepath.Join("tests", "easy", "1.in"), Verdict: VerdictAC},
+		{Name: filepath.Join("tests", "hard", "1.in"), Verdict: VerdictWA},
+		{Name: filepath.Join("tests", "easy", "2.in"), Verdict: VerdictSlow},
+		{Name: filepath.Join("tests", "hard", "2.in"), Verdict: VerdictAC},
+	}
+	if got, want := directorySummary(tests), "tests/easy/: 2/2, tests/hard/: 1/2"; got != want {
+		t.Errorf("directorySummary() = %q, want %q", got, want)
+	}
+	if got := directorySummary(tests[:1]); got != "" {
+		t.Errorf("directorySummary() of one directory = %q, want none", got)
+	}
+}
+
+func TestDetectInterpreter(t *testing.T) {
+	tests := map[string]string{
+		"solution.py":  "python3",
+		"./sol.JS":     "node",
+		"tests/gen.rb": "ruby",
+		"lib/sol.jar":  "java -jar",
+		"./solution":   "",
+		"sol.exe":      "",
+	}
+	for program, want := range tests {
+		if got := strings.Join(detectInterpreter(program), " "); got != want {
+			t.Errorf("detectInterpreter(%q) = %q, want %q", program, got, want)
+		}
+	}
+}
+
+func TestReproCommand(t *testing.T) {
+	dir := t.TempDir()
+	described := filepath.Join(dir, "described.in")
+	if err := os.WriteFile(described, []byte("#: big case\n1 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		inputFile string
+		opts      execOptions
+		want      string
+	}{
+		{"tests/1.in", execOptions{}, "./sol < tests/1.in"},
+		{"tests/it's.in", execOptions{interpreter: []string{"python3"}}, `python3 ./sol < 'tests/it'\''s.in'`},
+		{"tests/1.in", execOptions{dir: "work"}, "(cd work && exec ./sol) < tests/1.in"},
+		{"tests/1.cmd", execOptions{post: "sort"}, "sh tests/1.cmd | ./sol | sh -c sort"},
+		{"tests/1.in", execOptions{pre: "tr a b", inputSuffix: "\n"}, `{ sh -c 'tr a b' < tests/1.in | awk 1; printf '\n'; } | ./sol`},
+		{"tests/1.in", execOptions{inputSuffix: "0 0\n"}, `{ awk 1 < tests/1.in; printf '0 0\n'; } | ./sol`},
+		{described, execOptions{}, "./sol < " + described},
+		{described, execOptions{descriptionLine: true}, "tail -n +2 " + described + " | ./sol"},
+		{"tests/1.in", execOptions{args: []string{"-n", "a b"}, seedArg: SeedArgIndex, seedIndex: 3}, "./sol -n 'a b' 3 < tests/1.in"},
+		{described, execOptions{seedArg: SeedArgInput, descriptionLine: true}, "tail -n +2 " + described + " | ./sol 1"},
+	}
+	for _, tt := range tests {
+		if got := reproCommand("./sol", tt.inputFile, tt.opts); got != tt.want {
+			t.Errorf("reproCommand(%q, %+v) = %q, want %q", tt.inputFile, tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	buffer := newCappedBuffer(4)
+	for _, chunk := range []string{"ab", "cde", "fgh"} {
+		if n, err := buffer.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v; want %d, nil", chunk, n, err, len(chunk))
+		}
+	}
+	if got, want := buffer.String(), "abcd\n... (4B more not kept, see -stderr-buffer)\n"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+// TestStdinAppend runs a real program reading lines up to a "0 0" sentinel appended by
+// -stdin-append, which has to arrive on a line of its own after the input's last line
+func TestStdinAppend(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	dir := t.TempDir()
+	program := filepath.Join(dir, "sum.sh")
+	script := "while read a b; do\n  [ \"$a $b\" = \"0 0\" ] && exit 0\n  echo $((a+b))\ndone\necho no sentinel\n"
+	if err := os.WriteFile(program, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	temp, err := newTempStore(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer temp.cleanup()
+	for _, input := range []string{"1 2\n3 4\n", "1 2\n3 4"} {
+		inputFile := filepath.Join(dir, "1.in")
+		if err := os.WriteFile(inputFile, []byte(input), 0644); err != nil {
+			t.Fatal(err)
+		}
+		opts := execOptions{timeout: 10 * time.Second, interpreter: []string{"sh"}, temp: temp, inputSuffix: "0 0\n"}
+		result, err := executeProgram(program, inputFile, opts)
+		if err != nil {
+			t.Fatalf("executeProgram(%q) failed: %v", input, err)
+		}
+		if result.output != "3\n7\n" {
+			t.Errorf("executeProgram(%q) output = %q, want the sums before the sentinel", input, result.output)
+		}
+	}
+}
+
+// TestDescriptionLine runs a real program on inputs starting with "#:", which only
+// loses that line under -inline-desc when the test has no .desc file
+func TestDescriptionLine(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	dir := t.TempDir()
+	program := filepath.Join(dir, "cat.sh")
+	input := "#: big case\n1 2"
+	files := map[string]string{"cat.sh": "cat\n", "plain.in": input, "desc.in": input, "desc.desc": "from the desc file\n"}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	temp, err := newTempStore(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer temp.cleanup()
+	for _, tt := range []struct {
+		test   string
+		inline bool
+		want   string
+	}{
+		{"plain", false, input},
+		{"desc", true, input},
+		{"plain", true, "1 2"},
+	} {
+		base := filepath.Join(dir, tt.test)
+		_, fromInput := readDescription(base, base+".in", tt.inline)
+		opts := execOptions{timeout: 10 * time.Second, interpreter: []string{"sh"}, temp: temp, descriptionLine: fromInput}
+		result, err := executeProgram(program, base+".in", opts)
+		if err != nil {
+			t.Fatalf("executeProgram(%s) failed: %v", tt.test, err)
+		}
+		if result.output != tt.want {
+			t.Errorf("%s with inline %v: the program read %q, want %q", tt.test, tt.inline, result.output, tt.want)
+		}
+	}
+}
+
+// TestChattyStderr runs a real program that writes far more to stderr than a pipe
+// holds, to make sure it's drained while stdout is read instead of deadlocking
+func TestChattyStderr(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	dir := t.TempDir()
+	program := filepath.Join(dir, "chatty.sh")
+	script := "i=0\nwhile [ $i -lt 2000 ]; do\n  echo \"debug line $i of some chatty output that fills the pipe\" >&2\n  i=$((i+1))\ndone\necho done\n"
+	inputFile := filepath.Join(dir, "1.in")
+	for name, content := range map[string]string{program: script, inputFile: ""} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	temp, err := newTempStore(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer temp.cleanup()
+	for _, opts := range []execOptions{
+		{captureStderr: true, stderrLimit: 1024},
+		{captureStderr: true, hash: true},
+		{},
+		{hash: true},
+	} {
+		opts.timeout, opts.interpreter, opts.temp = 10*time.Second, []string{"sh"}, temp
+		result, err := executeProgram(program, inputFile, opts)
+		if err != nil {
+			t.Fatalf("executeProgram(%+v) failed: %v", opts, err)
+		}
+		if !opts.hash && result.output != "done\n" {
+			t.Errorf("executeProgram(%+v) output = %q, want done", opts, result.output)
+		}
+		if opts.captureStderr && !strings.HasPrefix(result.stderr, "debug line 0 ") {
+			t.Errorf("executeProgram(%+v) kept stderr %.40q", opts, result.stderr)
+		}
+		if opts.stderrLimit > 0 && !strings.Contains(result.stderr, "more not kept") {
+			t.Errorf("executeProgram(%+v) kept all of stderr despite the limit", opts)
+		}
+	}
+}
+
+// TestStrictInput runs real programs that time out after reading their input, to
+// check -strict-input tells one that keeps reading stdin from one that computes
+func TestStrictInput(t *testing.T) {
+	if _, err := readIOCounters(os.Getpid()); err != nil {
+		t.Skipf("I/O counters unavailable: %v", err)
+	}
+	dir := t.TempDir()
+	inputFile := filepath.Join(dir, "1.in")
+	if err := os.WriteFile(inputFile, []byte("1 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	temp, err := newTempStore(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer temp.cleanup()
+	for script, want := range map[string]string{
+		"read a b\necho $((a+b))\nwhile true; do read x; done\n": "waiting for more input",
+		"read a b\nwhile true; do :; done\n":                     "computing",
+	} {
+		program := filepath.Join(dir, "program.sh")
+		if err := os.WriteFile(program, []byte(script), 0644); err != nil {
+			t.Fatal(err)
+		}
+		opts := execOptions{timeout: 500 * time.Millisecond, interpreter: []string{"sh"}, temp: temp, strictInput: true}
+		result, err := executeProgram(program, inputFile, opts)
+		if err != context.DeadlineExceeded {
+			t.Fatalf("executeProgram(%q) err = %v, want a timeout", script, err)
+		}
+		if message := timeoutMessage(result, opts); !strings.Contains(message, want) {
+			t.Errorf("executeProgram(%q) timed out with %q, want %q", script, message, want)
+		}
+	}
+}
+
+func TestRunShowConfig(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{"1": {"1 2", "3"}, "2": {"hang", "1"}})
+	var out bytes.Buffer
+	cfg := Config{Program: "fake", Pattern: filepath.Join(dir, "*.in"), ShowConfig: true, Timeout: 2 * time.Second, Rows: true, Eps: 1e-6, ExtraLines: true}
+	results, err := (&Runner{Out: &out, executor: fakeExecutor{}}).Run(cfg)
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if len(results.Tests) != 0 {
+		t.Errorf("-show-config ran %d tests", len(results.Tests))
+	}
+	for _, want := range []string{
+		"tests:        2 matching",
+		"comparison:   tokens, line by line, numbers within 1e-06; extra trailing lines ignored",
+		"timeout:      2s",
+		"expected:     .out files next to each input",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("-show-config output lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestRunMatrix(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"1": {"args", "-n 5"},
+		"2": {"1 2", "3"},
+	})
+	var out bytes.Buffer
+	cfg := Config{Program: "fake", Pattern: filepath.Join(dir, "*.in"), ProgramArgs: []string{"-n"}, Matrix: [][]string{{"5"}, {"6"}}}
+	results, err := (&Runner{Out: &out, executor: fakeExecutor{}}).Run(cfg)
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	verdicts := make(map[string]string)
+	for _, result := range results.Tests {
+		verdicts[filepath.Base(result.Name)] = result.Verdict
+	}
+	want := map[string]string{"1.in [5]": VerdictAC, "2.in [5]": VerdictAC, "1.in [6]": VerdictWA, "2.in [6]": VerdictAC}
+	if len(verdicts) != len(want) {
+		t.Errorf("got verdicts %v, want %v", verdicts, want)
+	}
+	for name, verdict := range want {
+		if verdicts[name] != verdict {
+			t.Errorf("%s: got verdict %s, want %s", name, verdicts[name], verdict)
+		}
+	}
+	if !results.Failed {
+		t.Error("a failing argument set didn't fail the run")
+	}
+	if !strings.Contains(out.String(), "Every test passes with: 5") {
+		t.Errorf("summary doesn't name the passing argument set:\n%s", out.String())
+	}
+}
+
+func TestRunAnnotationsGoToOut(t *testing.T) {
+	dir := writeTests(t, map[string][2]string{
+		"ac": {"1 2", "3"},
+		"wa": {"2 2", "5"},
+	})
+	var out bytes.Buffer
+	cfg := Config{Program: "fake", Pattern: filepath.Join(dir, "*.in"), GitHub: true, Quickfix: true}
+	if _, err := (&Runner{Out: &out, executor: fakeExecutor{}}).Run(cfg); err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	for _, want := range []string{
+		"::error file=" + filepath.Join(dir, "wa.in") + ",title=WA ",
+		filepath.Join(dir, "wa.out") + ":1: WA ",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Runner.Out lacks %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "ac.in,title") {
+		t.Errorf("a passing test was annotated:\n%s", out.String())
+	}
+}
+
+func TestConfirmOnStdin(t *testing.T) {
+	for _, tt := range []struct {
+		answer string
+		want   bool
+	}{
+		{"y\n", true},
+		{"Yes\n", true},
+		{"n\n", false},
+		{"", false},
+	} {
+		var out bytes.Buffer
+		got := confirmOnStdin(&out, bufio.NewReader(strings.NewReader(tt.answer)), "Update?")
+		if got != tt.want {
+			t.Errorf("confirmOnStdin(%q) = %v, want %v", tt.answer, got, tt.want)
+		}
+		if !strings.HasPrefix(out.String(), "Update? [y/N] ") {
+			t.Errorf("confirmOnStdin(%q) printed %q, want the question", tt.answer, out.String())
+		}
+	}
+}
@@ -0,0 +1,619 @@
+package harn
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// test is a single test of a run while it runs
+type test struct {
+	inputFile string
+	// testBase is the base name of the test's per-test files, outputFile its expected file
+	testBase   string
+	outputFile string
+	opts       execOptions
+	cmpOpts    compareOptions
+	// line and details collect what the terminal shows of the test, its result line
+	// and the sections below it
+	line, details bytes.Buffer
+	// update is set when Update may overwrite the expected file with actual, the output of a WA
+	update bool
+	actual string
+}
+
+// runTest runs a single test and hands its result to the reporters. Everything but the
+// program itself runs under the lock, which keeps the counters and results consistent.
+func (rn *run) runTest(inputFile string) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	if rn.cfg.MaxFailures > 0 && countFailures(rn.results) >= rn.cfg.MaxFailures {
+		rn.notRun++
+		rn.totalTests--
+		return
+	}
+	// Tests completed before the run was interrupted keep their previous result
+	if previous, ok := rn.resume.completed(inputFile); ok {
+		rn.results = append(rn.results, previous)
+		rn.totalExecutionTime += previous.Time
+		switch previous.Verdict {
+		case VerdictSkip:
+			rn.passedTests++
+		case VerdictGen:
+			rn.generatedFiles++
+		case VerdictTLE:
+			rn.timedOutTests++
+		case VerdictSlow:
+			rn.slowTests++
+		}
+		if passed(previous.Verdict) {
+			rn.passedTests++
+		}
+		rn.report(previous)
+		return
+	}
+
+	finished := len(rn.results)
+	t := rn.newTest(inputFile)
+	if rn.cfg.Generate {
+		rn.generate(t)
+	} else {
+		rn.check(t)
+	}
+	// Other tests may finish while this one runs, so its result is looked up by name
+	for i := finished; i < len(rn.results); i++ {
+		if rn.results[i].Name != inputFile {
+			continue
+		}
+		// Asking to update the expected file waits until the test is reported, so the
+		// question follows its diff. Without a question the update is part of the report.
+		if t.update && rn.cfg.AssumeYes {
+			rn.updateExpected(t, &t.details)
+		}
+		rn.results[i].line, rn.results[i].Details = t.line.String(), t.details.String()
+		rn.report(rn.results[i])
+		if t.update && !rn.cfg.AssumeYes {
+			rn.updateExpected(t, rn.out)
+		}
+	}
+}
+
+// report hands the result of a finished test to the reporters
+func (rn *run) report(result TestResult) {
+	for _, reporter := range rn.reporters {
+		reporter.TestDone(result)
+	}
+}
+
+// newTest works out the files and options of a test. Notes about them, like a scaled
+// timeout, go on the test's result line.
+func (rn *run) newTest(inputFile string) *test {
+	cfg := rn.cfg
+	t := &test{inputFile: inputFile, opts: rn.opts, cmpOpts: rn.cmpOpts}
+	t.testBase, t.outputFile = rn.testFiles(inputFile)
+
+	// Scale the timeout by the test's relative weight, if it has a .weight file
+	if weight, err := readWeight(t.testBase + ".weight"); err != nil {
+		Log.Warnf("%s: ignoring weight: %v", inputFile, err)
+	} else if weight != 1 {
+		t.opts.timeout = time.Duration(float64(cfg.Timeout) * weight)
+		t.opts.cpuLimit = time.Duration(float64(cfg.CPULimit) * weight)
+		fmt.Fprintf(&t.line, "(timeout x%g: %v) ", weight, t.opts.timeout)
+	}
+	t.opts.outputFile = strings.ReplaceAll(cfg.OutputFile, "{name}", filepath.Base(t.testBase))
+	if c, ok := rn.inline[inputFile]; ok {
+		t.opts.input = &c.input
+	}
+	t.opts.seedIndex = rn.seedIndex[inputFile]
+	t.opts.descriptionLine = rn.descriptionLines[inputFile]
+	// Each test is compared in the format of the expected file it has, whichever -h asks for
+	if _, ok := rn.inline[inputFile]; !ok && rn.detectFormats {
+		useHash, ambiguous := detectExpectedFormat(t.testBase, cfg.Hash)
+		if ambiguous {
+			// Noted on the result line like a weight, as a warning would break it up
+			fmt.Fprintf(&t.line, "%s(both .out and .hash exist, comparing the %s)%s ", Yellow, rn.expectedExt, Reset)
+		}
+		if useHash != cfg.Hash {
+			testExt := ".out"
+			if useHash {
+				testExt = ".hash"
+				// Hashes only ever match exactly, whatever the comparison mode
+				t.cmpOpts = compareOptions{trim: TrimFull}
+			}
+			t.opts.hash, t.outputFile = useHash, platformExpected(t.testBase+testExt, false)
+		}
+	}
+	return t
+}
+
+// execute runs the program with the lock released, so that tests run in parallel under Jobs
+func (rn *run) execute(program, inputFile string, testOpts execOptions) (execResult, error) {
+	rn.mu.Unlock()
+	result, err := rn.executor.execute(program, inputFile, testOpts)
+	rn.mu.Lock()
+	if err == nil {
+		rn.outputs.add(inputFile, result.outputSize)
+		// Stripped before anything looks at the output, so diffs and generated files are plain too
+		if rn.cfg.StripANSI {
+			result.output = stripANSI(result.output)
+		}
+	}
+	rn.lastRun = result
+	return result, err
+}
+
+// record adds the result of a test, and saves it to the checkpoint under ResumeFile
+func (rn *run) record(inputFile, verdict string, executionTime time.Duration) {
+	result := TestResult{Name: inputFile, Verdict: verdict, Time: executionTime}
+	rn.results = append(rn.results, result)
+	if rn.resume != nil {
+		if err := rn.resume.add(result); err != nil {
+			Log.Warnf("Failed to update checkpoint %s: %v", rn.cfg.ResumeFile, err)
+		}
+	}
+}
+
+// recordFailure records a failing verdict with a short explanation, quarantined
+// tests are recorded as FLAKY instead
+func (rn *run) recordFailure(inputFile, verdict string, executionTime time.Duration, message string) {
+	if rn.quarantined[inputFile] {
+		verdict = VerdictFlaky
+	}
+	rn.record(inputFile, verdict, executionTime)
+	rn.results[len(rn.results)-1].Message = message
+}
+
+// failureLabel renders the colored verdict and a note for a failing test. Quarantined
+// tests are shown as FLAKY, and failures that were already failing in the previous run
+// are dimmed under -new-failures-only.
+func (rn *run) failureLabel(inputFile, verdict, color string) (string, string) {
+	note := ""
+	if rn.quarantined[inputFile] {
+		verdict, color = fmt.Sprintf("%s (%s)", VerdictFlaky, verdict), Magenta
+	}
+	if rn.cfg.NewFailuresOnly {
+		if rn.previousFailures[inputFile] {
+			color, note = Gray, " (already failing)"
+		} else {
+			note = fmt.Sprintf(" %s[NEW]%s", Magenta, Reset)
+		}
+	}
+	return color + verdict + Reset, note
+}
+
+// showsDiff reports whether a failing test's diff is shown, dimmed failures that were
+// already failing under -new-failures-only don't get one
+func (rn *run) showsDiff(inputFile string) bool {
+	return !rn.cfg.Silent && !(rn.cfg.NewFailuresOnly && rn.previousFailures[inputFile])
+}
+
+// reproHint shows how to run the program by hand on a failing test, under -repro-hint
+func (rn *run) reproHint(t *test) {
+	if rn.cfg.ReproHint {
+		fmt.Fprintf(&t.details, "    Reproduce: %s\n", reproCommand(rn.programPath, t.inputFile, t.opts))
+	}
+}
+
+// timeLabel formats an execution time, along with its adjusted time under -overhead
+func (rn *run) timeLabel(executionTime, timeout time.Duration) string {
+	label := formatTime(executionTime, timeout, rn.cfg.TimeColors)
+	if rn.overhead > 0 {
+		label += fmt.Sprintf(", adjusted %v", adjustedTime(executionTime, rn.overhead).Round(10*time.Microsecond))
+	}
+	return label
+}
+
+// writeExpected stores an output as a test's expected file, or as its golden directory under OutputDir
+func (rn *run) writeExpected(outputFile, output string) error {
+	if rn.cfg.OutputDir {
+		return writeTree(outputFile, output)
+	}
+	return writeFile(outputFile, output)
+}
+
+// generate writes the expected file of a test under Generate, unless it has one already
+func (rn *run) generate(t *test) {
+	cfg, line, inputFile := rn.cfg, &t.line, t.inputFile
+	exists := false
+	if rn.manifest != nil {
+		_, exists = rn.manifest.lookup(inputFile, t.testBase)
+	} else if _, err := os.Stat(t.outputFile); err == nil {
+		exists = true
+	}
+	if exists && !cfg.Force {
+		if rn.manifest != nil {
+			fmt.Fprintf(line, "%sSKIP%s: Hash found in %s, skipping\n", Gray, Reset, cfg.HashManifest)
+		} else {
+			fmt.Fprintf(line, "%sSKIP%s: Output file %s found, skipping\n", Gray, Reset, t.outputFile)
+		}
+		rn.record(inputFile, VerdictSkip, 0)
+		rn.passedTests++
+		return
+	}
+
+	generatorOpts := t.opts
+	generatorOpts.interpreter = rn.generatorInterpreter
+	result, err := rn.execute(rn.generatorPath, inputFile, generatorOpts)
+	actualOutput, executionTime := result.output, result.time
+	rn.totalExecutionTime += executionTime
+	execTimeStr := rn.timeLabel(executionTime, t.opts.timeout)
+
+	var exitErr *exitCodeError
+	if err != nil {
+		if err == context.DeadlineExceeded {
+			rn.timedOutTests++
+			fmt.Fprintf(line, "%s%s%s [%s]: %s\n", Gray, timeoutVerdict(result), Reset, execTimeStr, timeoutMessage(result, t.opts))
+			rn.record(inputFile, VerdictTLE, executionTime)
+		} else if errors.As(err, &exitErr) {
+			fmt.Fprintf(line, "%sRE%s [%s]: %v\n", Red, Reset, execTimeStr, exitErr)
+			rn.record(inputFile, VerdictRE, executionTime)
+		} else {
+			Log.Errorf("%s: executing %s: %v", inputFile, rn.generatorPath, err)
+			fmt.Fprintf(line, "%sERR%s [%s]: executing program: %v\n", Red, Reset, execTimeStr, err)
+			rn.record(inputFile, VerdictErr, executionTime)
+		}
+		return
+	}
+	if rn.manifest != nil {
+		rn.manifest.set(inputFile, t.testBase, actualOutput)
+		fmt.Fprintf(line, "%sGEN%s [%s]: Added hash to %s%s\n", Green, Reset, execTimeStr, cfg.HashManifest, rn.generatorNote)
+		rn.generatedFiles++
+		rn.record(inputFile, VerdictGen, executionTime)
+	} else if err = rn.writeExpected(t.outputFile, actualOutput); err != nil {
+		Log.Errorf("%s: writing generated output %s: %v", inputFile, t.outputFile, err)
+		fmt.Fprintf(line, "%sERR%s [%s]: failed while writing output: %v\n", Red, Reset, execTimeStr, err)
+		rn.record(inputFile, VerdictErr, executionTime)
+	} else {
+		fmt.Fprintf(line, "%sGEN%s [%s]: Wrote output file %s%s\n", Green, Reset, execTimeStr, t.outputFile, rn.generatorNote)
+		rn.generatedFiles++
+		rn.record(inputFile, VerdictGen, executionTime)
+	}
+}
+
+// check runs the program on a test and judges its output against the expected one
+func (rn *run) check(t *test) {
+	cfg, line, inputFile := rn.cfg, &t.line, t.inputFile
+	result, err := rn.execute(rn.programPath, inputFile, t.opts)
+	executionTime := result.time
+	rn.totalExecutionTime += executionTime
+	execTimeStr := rn.timeLabel(executionTime, t.opts.timeout)
+	if rn.rejected(t, result, err, execTimeStr) {
+		return
+	}
+
+	// The first run of a test records the program's output as the baseline of later runs
+	if cfg.SelfBaseline != "" && !expectedExists(t.outputFile) {
+		if err := rn.writeExpected(t.outputFile, result.output); err != nil {
+			rn.expectedError(t, executionTime, fmt.Errorf("recording baseline %s: %v", t.outputFile, err))
+			return
+		}
+		fmt.Fprintf(line, "%sGEN%s [%s]: Recorded baseline %s\n", Green, Reset, execTimeStr, t.outputFile)
+		rn.generatedFiles++
+		rn.record(inputFile, VerdictGen, executionTime)
+		return
+	}
+
+	var expectedFiles, expectedOutputs []string
+	if c, ok := rn.inline[inputFile]; ok && c.hasExpected {
+		expectedFiles, expectedOutputs = []string{inputFile}, []string{c.expected}
+	} else if cfg.ExpectedCmd != "" {
+		// The reference command reads the same input as the program, its output is the expected output
+		t.outputFile = expectedCmdLabel
+		expectedOutput, err := pipeThrough(expandInputPath(cfg.ExpectedCmd, inputFile), result.input, t.opts.timeout, expectedCmdLabel)
+		if err != nil {
+			Log.Errorf("%s: %v", inputFile, err)
+			label, note := rn.failureLabel(inputFile, VerdictErr, Red)
+			fmt.Fprintf(line, "%s: %v%s\n", label, err, note)
+			rn.recordFailure(inputFile, VerdictErr, executionTime, fmt.Sprintf("Running -expected-cmd: %v", err))
+			return
+		}
+		expectedFiles, expectedOutputs = []string{t.outputFile}, []string{expectedOutput}
+	} else if expectedFiles, expectedOutputs, err = rn.readExpected(t); err != nil {
+		rn.expectedError(t, executionTime, err)
+		return
+	}
+	if cfg.Expr {
+		for i, expectedFile := range expectedFiles {
+			if expectedOutputs[i], err = evalExpectedExpressions(expectedOutputs[i], result.input); err != nil {
+				rn.expectedError(t, executionTime, fmt.Errorf("evaluating expected output %s: %v", expectedFile, err))
+				return
+			}
+		}
+	}
+	if cfg.StripANSI {
+		for i := range expectedOutputs {
+			expectedOutputs[i] = stripANSI(expectedOutputs[i])
+		}
+	}
+	rn.judge(t, result, expectedFiles, expectedOutputs, execTimeStr)
+}
+
+// rejected reports a test that fails whatever its output is expected to be: the program
+// failed or timed out, wrote to stderr under NoStderr, gave another output on a second run
+// under CheckDeterminism, or an unsorted one under AssertSorted
+func (rn *run) rejected(t *test, result execResult, err error, execTimeStr string) bool {
+	cfg, line, details, inputFile := rn.cfg, &t.line, &t.details, t.inputFile
+	actualOutput, executionTime := result.output, result.time
+	var exitErr *exitCodeError
+	if err != nil {
+		if err == context.DeadlineExceeded {
+			rn.timedOutTests++
+			label, note := rn.failureLabel(inputFile, timeoutVerdict(result), Gray)
+			fmt.Fprintf(line, "%s [%s]: %s%s\n", label, execTimeStr, timeoutMessage(result, t.opts), note)
+			rn.recordFailure(inputFile, VerdictTLE, executionTime, timeoutMessage(result, t.opts))
+		} else if errors.As(err, &exitErr) {
+			label, note := rn.failureLabel(inputFile, VerdictRE, Red)
+			fmt.Fprintf(line, "%s [%s]: %v%s\n", label, execTimeStr, exitErr, note)
+			rn.recordFailure(inputFile, VerdictRE, executionTime, fmt.Sprintf("Program %v", exitErr))
+		} else {
+			Log.Errorf("%s: executing %s: %v", inputFile, rn.programPath, err)
+			label, note := rn.failureLabel(inputFile, VerdictErr, Red)
+			fmt.Fprintf(line, "%s [%s]: executing program: %v%s\n", label, execTimeStr, err, note)
+			rn.recordFailure(inputFile, VerdictErr, executionTime, fmt.Sprintf("Executing program: %v", err))
+		}
+		rn.reproHint(t)
+		return true
+	}
+
+	// Anything on stderr fails the test under NoStderr, however correct the output
+	if cfg.NoStderr && result.stderr != "" {
+		label, note := rn.failureLabel(inputFile, VerdictStderr, Red)
+		fmt.Fprintf(line, "%s [%s]: Program wrote %d byte(s) to stderr%s\n", label, execTimeStr, len(result.stderr), note)
+		rn.recordFailure(inputFile, VerdictStderr, executionTime, "Program wrote to stderr:\n"+result.stderr)
+		rn.reproHint(t)
+		if !cfg.Silent {
+			stderrText, truncated := truncateLines(strings.TrimSuffix(result.stderr, "\n"), cfg.MaxDiffLines)
+			fmt.Fprintf(details, " === Stderr:\n%s\n", stderrText)
+			if truncated {
+				fmt.Fprintf(details, "... (truncated)\n")
+			}
+			fmt.Fprintf(details, " === End Stderr\n")
+		}
+		return true
+	}
+
+	// Under CheckDeterminism a second run has to reproduce the output, before it is compared with the expected one
+	if cfg.CheckDeterminism {
+		second, err := rn.execute(rn.programPath, inputFile, t.opts)
+		message := ""
+		if err == context.DeadlineExceeded {
+			message = "Second run timed out: " + timeoutMessage(second, t.opts)
+		} else if err != nil {
+			message = fmt.Sprintf("Second run failed: %v", err)
+		} else if normalizeOutput(second.output, cfg.Trim) != normalizeOutput(actualOutput, cfg.Trim) {
+			message = "Output differs between two runs"
+		}
+		if message != "" {
+			label, note := rn.failureLabel(inputFile, VerdictNondeterministic, Red)
+			fmt.Fprintf(line, "%s [%s]: %s%s\n", label, execTimeStr, message, note)
+			if err == nil {
+				message += "\n" + diffSnippet("first run", actualOutput, second.output)
+			}
+			rn.recordFailure(inputFile, VerdictNondeterministic, executionTime, message)
+			rn.reproHint(t)
+			if err == nil && rn.showsDiff(inputFile) {
+				dmp := diffmatchpatch.New()
+				diffs := diffOutputs(dmp, actualOutput, second.output, cfg.DiffGranularity)
+				if cfg.ShowWhitespace {
+					diffs = showWhitespace(diffs)
+				}
+				fmt.Fprintf(details, " === Diff of first and second run:\n")
+				diff, truncated := truncateLines(prettyDiff(dmp, diffs), cfg.MaxDiffLines)
+				fmt.Fprintln(details, diff)
+				if truncated {
+					fmt.Fprintf(details, "... (truncated)\n")
+				}
+				fmt.Fprintf(details, " === End Diff\n")
+			}
+			return true
+		}
+	}
+
+	// Under AssertSorted the output has to be in order, whatever it is expected to be
+	if cfg.AssertSorted != "" {
+		if ok, mismatch := checkSorted(actualOutput, cfg.AssertSorted, cfg.Delims); !ok {
+			label, note := rn.failureLabel(inputFile, VerdictWA, Red)
+			fmt.Fprintf(line, "%s [%s]: Output is not sorted: %s%s\n", label, execTimeStr, mismatch, note)
+			rn.recordFailure(inputFile, VerdictWA, executionTime, "Output is not sorted: "+mismatch)
+			rn.reproHint(t)
+			return true
+		}
+	}
+	return false
+}
+
+// readExpected reads the acceptable expected outputs of a test from the hash manifest,
+// its subtask's section of a shared file, or its expected file and any numbered
+// alternates like test1.out.2
+func (rn *run) readExpected(t *test) (expectedFiles, expectedOutputs []string, err error) {
+	cfg := rn.cfg
+	if rn.manifest != nil {
+		t.outputFile = cfg.HashManifest
+		expectedOutput, ok := rn.manifest.lookup(t.inputFile, t.testBase)
+		if !ok {
+			return nil, nil, fmt.Errorf("%s has no hash for %s (use -g to add it)", cfg.HashManifest, t.inputFile)
+		}
+		return []string{t.outputFile}, []string{expectedOutput}, nil
+	}
+	if sharedFile := platformExpected(subtaskFile(t.testBase, rn.expectedExt), false); cfg.Subtasks && !expectedExists(t.outputFile) && expectedExists(sharedFile) {
+		// Without an expected file of its own, the test's answer is its subtask's section of the shared one
+		t.outputFile = sharedFile
+		tags, err := readTags(t.testBase)
+		if err != nil {
+			return nil, nil, err
+		}
+		expectedOutput, err := readSubtaskSection(t.outputFile, tags)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []string{t.outputFile}, []string{expectedOutput}, nil
+	}
+
+	expectedFiles = expectedAlternates(t.outputFile)
+	expectedOutputs = make([]string, len(expectedFiles))
+	for i, expectedFile := range expectedFiles {
+		if cfg.Binary {
+			var data []byte
+			data, err = os.ReadFile(expectedFile)
+			expectedOutputs[i] = string(data)
+		} else if cfg.OutputDir {
+			expectedOutputs[i], err = readTree(expectedFile)
+		} else {
+			expectedOutputs[i], err = readFile(expectedFile)
+		}
+		if err != nil {
+			return nil, nil, expectedFileError(expectedFile, err)
+		}
+	}
+	return expectedFiles, expectedOutputs, nil
+}
+
+// expectedError fails a test whose expected output couldn't be found or read as ERR
+func (rn *run) expectedError(t *test, executionTime time.Duration, err error) {
+	Log.Errorf("%s: %v", t.inputFile, err)
+	label, note := rn.failureLabel(t.inputFile, VerdictErr, Red)
+	fmt.Fprintf(&t.line, "%s: %v%s\n", label, err, note)
+	message := err.Error()
+	rn.recordFailure(t.inputFile, VerdictErr, executionTime, strings.ToUpper(message[:1])+message[1:])
+}
+
+// judge compares the program's output with the expected outputs of a test and reports
+// the verdict, with a diff of a wrong answer. Under Update the expected file of a wrong
+// answer may then be overwritten with the output.
+func (rn *run) judge(t *test, result execResult, expectedFiles, expectedOutputs []string, execTimeStr string) {
+	cfg, line, details, inputFile := rn.cfg, &t.line, &t.details, t.inputFile
+	actualOutput, executionTime := result.output, result.time
+	rn.lastExpected = expectedOutputs
+	judged := judge(expectedOutputs, actualOutput, t.cmpOpts)
+	matched, whitespaceOnly, mismatch := judged.matched, judged.whitespaceOnly, judged.mismatch
+
+	if judged.verdict == VerdictAC {
+		matchNote := ""
+		if expectedFiles[matched] != t.outputFile {
+			matchNote = fmt.Sprintf(" (alternate %s)", expectedFiles[matched])
+		}
+		if whitespaceOnly {
+			matchNote += " except for whitespace"
+		}
+		if judged.extraLines > 0 {
+			matchNote += fmt.Sprintf(", ignoring %d extra line(s)", judged.extraLines)
+		}
+		if cfg.SoftLimit > 0 && executionTime > cfg.SoftLimit {
+			fmt.Fprintf(line, "%sSLOW%s [%s]: Output matches expected result%s but exceeded %v soft limit\n", Yellow, Reset, execTimeStr, matchNote, cfg.SoftLimit)
+			rn.slowTests++
+			rn.record(inputFile, VerdictSlow, executionTime)
+		} else if whitespaceOnly {
+			fmt.Fprintf(line, "%sAC (whitespace)%s [%s]: Output matches expected result%s\n", Yellow, Reset, execTimeStr, matchNote)
+			rn.record(inputFile, VerdictAC, executionTime)
+		} else {
+			fmt.Fprintf(line, "%sAC%s [%s]: Output matches expected result%s\n", Green, Reset, execTimeStr, matchNote)
+			rn.record(inputFile, VerdictAC, executionTime)
+		}
+		rn.passedTests++
+		if cfg.Verbose {
+			fmt.Fprintf(details, " === Expected:\n%s\n", expectedOutputs[matched])
+			fmt.Fprintf(details, " === End Expected:\n")
+			fmt.Fprintf(details, " === Actual:\n%s\n", actualOutput)
+			fmt.Fprintf(details, " === End Actual:\n")
+			printPerfStats(details, result.perfStats)
+		}
+		return
+	}
+
+	label, note := rn.failureLabel(inputFile, VerdictWA, Red)
+	if cfg.OutputDir {
+		if comparison, err := compareTrees(expectedOutputs[0], actualOutput); err == nil {
+			mismatch = comparison.String()
+		}
+	}
+	if mismatch != "" {
+		mismatch = " (" + mismatch + ")"
+	}
+	fmt.Fprintf(line, "%s [%s]: Output doesn't match%s%s\n", label, execTimeStr, mismatch, note)
+	rn.reproHint(t)
+	snippet := diffSnippet
+	if cfg.Binary {
+		snippet = binarySnippet
+	}
+	rn.recordFailure(inputFile, VerdictWA, executionTime, "Output doesn't match"+mismatch+"\n"+snippet(expectedFiles[0], expectedOutputs[0], actualOutput))
+	if cfg.ExpectedCmd == "" {
+		last := &rn.results[len(rn.results)-1]
+		last.quickfix = quickfixLine(*last, expectedFiles[0], expectedOutputs[0], actualOutput, judged.mismatch, cfg.Binary)
+	}
+	if cfg.Binary && rn.showsDiff(inputFile) {
+		// A textual diff of binary data is unreadable, show where the bytes diverge instead
+		for i, expectedOutput := range expectedOutputs {
+			offset := firstDifferingByte(expectedOutput, actualOutput)
+			fmt.Fprintf(details, " === Expected%s at offset 0x%x:\n%s\n", alternateLabel(expectedFiles, i), offset, hexDump(expectedOutput, offset))
+			fmt.Fprintf(details, " === Actual at offset 0x%x:\n%s\n", offset, hexDump(actualOutput, offset))
+		}
+		fmt.Fprintf(details, " === End Hex Dump\n")
+		printPerfStats(details, result.perfStats)
+	} else if cfg.Verbose {
+		for i, expectedOutput := range expectedOutputs {
+			fmt.Fprintf(details, " === Expected%s:\n%s\n", alternateLabel(expectedFiles, i), expectedOutput)
+			fmt.Fprintf(details, " === End Expected:\n")
+		}
+		fmt.Fprintf(details, " === Actual:\n%s\n", actualOutput)
+		fmt.Fprintf(details, " === End Actual:\n")
+		printPerfStats(details, result.perfStats)
+	} else if rn.showsDiff(inputFile) {
+		dmp := diffmatchpatch.New()
+
+		for i, expectedOutput := range expectedOutputs {
+			// Under OutputDir every changed file gets a diff of its own
+			sections := []treeFileChange{{expected: expectedOutput, actual: actualOutput}}
+			if cfg.OutputDir {
+				if comparison, err := compareTrees(expectedOutput, actualOutput); err == nil {
+					sections = comparison.changed
+				}
+			}
+			for _, section := range sections {
+				diffs := diffOutputs(dmp, section.expected, section.actual, cfg.DiffGranularity)
+				if cfg.ShowWhitespace {
+					diffs = showWhitespace(diffs)
+				}
+
+				label := alternateLabel(expectedFiles, i)
+				if section.path != "" {
+					label += " of " + section.path
+				}
+				fmt.Fprintf(details, " === Diff%s:\n", label)
+				diff, truncated := truncateLines(prettyDiff(dmp, diffs), cfg.MaxDiffLines)
+				fmt.Fprintln(details, diff)
+				if truncated {
+					fmt.Fprintf(details, "... (truncated, use -v)\n")
+				}
+			}
+		}
+		fmt.Fprintf(details, " === End Diff (💡 Use -v flag for full output)\n")
+	}
+
+	t.update, t.actual = cfg.Update, actualOutput
+}
+
+// updateExpected overwrites the expected file of a failing test with its output under
+// Update, or its hash in the manifest, once confirmed. The outcome goes to out.
+func (rn *run) updateExpected(t *test, out io.Writer) {
+	cfg, inputFile, outputFile := rn.cfg, t.inputFile, t.outputFile
+	if rn.manifest != nil && (cfg.AssumeYes || rn.confirm(fmt.Sprintf("Update the hash of %s in %s?", inputFile, outputFile))) {
+		rn.manifest.set(inputFile, t.testBase, t.actual)
+		fmt.Fprintf(out, "%sUPD%s: Updated hash in %s\n", Cyan, Reset, outputFile)
+		rn.updatedFiles++
+	} else if rn.manifest == nil && (cfg.AssumeYes || rn.confirm(fmt.Sprintf("Overwrite %s with the actual output?", outputFile))) {
+		if err := rn.writeExpected(outputFile, t.actual); err != nil {
+			Log.Errorf("%s: updating expected output %s: %v", inputFile, outputFile, err)
+			fmt.Fprintf(out, "%sERR%s: failed while updating expected output: %v\n", Red, Reset, err)
+		} else {
+			fmt.Fprintf(out, "%sUPD%s: Updated expected output file %s\n", Cyan, Reset, outputFile)
+			rn.updatedFiles++
+		}
+	}
+}
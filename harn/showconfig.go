@@ -0,0 +1,185 @@
+package harn
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// printConfig shows the configuration a run resolved to under -show-config, after
+// defaults, -problem limits and detection from the program and test files
+func printConfig(out io.Writer, cfg Config, programPath string, cmpOpts compareOptions, tests []string, globPattern string) {
+	field := func(name, format string, args ...interface{}) {
+		fmt.Fprintf(out, "  %-13s %s\n", name+":", fmt.Sprintf(format, args...))
+	}
+	fmt.Fprintln(out, "Configuration:")
+	program := programPath
+	if len(cfg.Interpreter) > 0 {
+		program = strings.Join(cfg.Interpreter, " ") + " " + program
+	}
+	if len(cfg.ProgramArgs) > 0 {
+		program += " " + strings.Join(cfg.ProgramArgs, " ")
+	}
+	if cfg.SeedArg != "" {
+		program += fmt.Sprintf(" <seed by %s>", cfg.SeedArg)
+	}
+	field("program", "%s", program)
+	testSource := fmt.Sprintf("%d matching %q", len(tests), globPattern)
+	if cfg.CasesFile != "" {
+		testSource += " and inline cases in " + cfg.CasesFile
+	}
+	field("tests", "%s", testSource)
+	field("mode", "%s", configMode(cfg))
+	field("expected", "%s", expectedSource(cfg))
+	field("comparison", "%s", describeComparison(cfg, cmpOpts))
+	field("trim", "%s", cfg.Trim)
+	field("timeout", "%v", cfg.Timeout)
+	field("soft limit", "%s", orNone(cfg.SoftLimit))
+	field("cpu limit", "%s", orNone(cfg.CPULimit))
+	field("idle timeout", "%s", orNone(cfg.IdleTimeout))
+	memory := "none"
+	if cfg.MemLimit > 0 {
+		memory = FormatMemory(cfg.MemLimit)
+	}
+	if cfg.MemBudget > 0 {
+		memory += ", budget " + FormatMemory(cfg.MemBudget)
+	}
+	field("memory limit", "%s", memory)
+	jobs := cfg.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	field("jobs", "%d", jobs)
+	if cfg.Dir != "" {
+		field("working dir", "%s", cfg.Dir)
+	}
+	if cfg.Pre != "" {
+		field("pre", "%s", cfg.Pre)
+	}
+	if cfg.Post != "" {
+		field("post", "%s", cfg.Post)
+	}
+}
+
+func orNone(d time.Duration) string {
+	if d == 0 {
+		return "none"
+	}
+	return d.String()
+}
+
+// configMode names what a run does with the tests' outputs
+func configMode(cfg Config) string {
+	switch {
+	case cfg.Generate && cfg.Force:
+		return "generate expected outputs, overwriting existing ones"
+	case cfg.Generate:
+		return "generate missing expected outputs"
+	case cfg.Update:
+		return "check, offering to update failing expected outputs"
+	}
+	return "check"
+}
+
+// expectedSource describes where a run takes the expected outputs from
+func expectedSource(cfg Config) string {
+	switch {
+	case cfg.Pattern == "-" && cfg.ExpectFile != "":
+		return "-expect-file " + cfg.ExpectFile
+	case cfg.Pattern == "-" && cfg.ExpectedCmd == "":
+		return fmt.Sprintf("-expect %q", cfg.Expect)
+	case cfg.ExpectedCmd != "":
+		return "output of -expected-cmd " + cfg.ExpectedCmd
+	case cfg.SelfBaseline != "":
+		return "recorded outputs in " + cfg.SelfBaseline
+	case cfg.HashManifest != "":
+		return "hashes in " + cfg.HashManifest
+	case cfg.OutTemplate != "":
+		return "files named by " + cfg.OutTemplate
+	case cfg.OutputDir:
+		return ".outdir directories next to each input"
+	case cfg.Hash:
+		return ".hash files next to each input"
+	}
+	source := ".out files next to each input"
+	if cfg.MatchExpected {
+		source = ".out files matched to the inputs by name"
+	}
+	if cfg.Subtasks {
+		source += ", or a section of the subtask's shared file"
+	}
+	return source
+}
+
+// describeComparison names the comparison mode and any modifiers, in the order compareOutputs tries them
+func describeComparison(cfg Config, cmpOpts compareOptions) string {
+	var parts []string
+	switch {
+	case cfg.Hash:
+		parts = append(parts, "SHA-256 hash of the output")
+	case cfg.OutputDir:
+		parts = append(parts, "files of the output directory")
+	case cmpOpts.binary:
+		parts = append(parts, "raw bytes")
+	case cmpOpts.contains:
+		parts = append(parts, "output contains the expected output")
+	case cmpOpts.multiset:
+		parts = append(parts, "tokens in any order")
+	case cmpOpts.tokens:
+		tokens := "tokens"
+		if cmpOpts.rows {
+			tokens = "tokens, line by line"
+		}
+		switch {
+		case cmpOpts.rounded:
+			tokens += fmt.Sprintf(", numbers rounded to %d decimals", cmpOpts.decimals)
+		case cmpOpts.eps > 0:
+			tokens += fmt.Sprintf(", numbers within %g", cmpOpts.eps)
+		case cmpOpts.intFloat:
+			tokens += ", whole numbers in integer or decimal spelling"
+		default:
+			tokens += ", numbers by value"
+		}
+		parts = append(parts, tokens)
+	case cmpOpts.fuzzy:
+		parts = append(parts, fmt.Sprintf("text within %d edits", cmpOpts.maxEdits))
+	default:
+		parts = append(parts, "exact text")
+	}
+	if cmpOpts.delims != "" && (cmpOpts.tokens || cmpOpts.multiset || len(cmpOpts.columns) > 0) {
+		parts = append(parts, fmt.Sprintf("split by %q", cmpOpts.delims))
+	}
+	if cmpOpts.leadingZeros {
+		parts = append(parts, "leading zeros ignored")
+	}
+	if len(cmpOpts.columns) > 0 {
+		columns := make([]string, len(cmpOpts.columns))
+		for i, column := range cmpOpts.columns {
+			columns[i] = fmt.Sprint(column)
+		}
+		parts = append(parts, "columns "+strings.Join(columns, ","))
+	}
+	if cmpOpts.commentPrefix != "" {
+		parts = append(parts, fmt.Sprintf("lines starting with %q ignored", cmpOpts.commentPrefix))
+	}
+	if cfg.StripANSI {
+		parts = append(parts, "ANSI escape codes removed")
+	}
+	if cmpOpts.extraLines {
+		parts = append(parts, "extra trailing lines ignored")
+	}
+	if cmpOpts.whitespace {
+		parts = append(parts, "whitespace-only differences pass")
+	}
+	if cfg.Expr {
+		parts = append(parts, "expected files evaluated as expressions")
+	}
+	if cfg.AssertSorted != "" {
+		parts = append(parts, "output "+cfg.AssertSorted+" must be sorted")
+	}
+	if cfg.CheckDeterminism {
+		parts = append(parts, "output must be deterministic")
+	}
+	return strings.Join(parts, "; ")
+}
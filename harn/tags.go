@@ -0,0 +1,206 @@
+package harn
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readTags collects the tags of a test: the words of its .tags file, and the
+// dot-separated parts of its file name after the first, so "12.large.slow.in"
+// is tagged large and slow
+func readTags(testBase string) (map[string]bool, error) {
+	tags := make(map[string]bool)
+	parts := strings.Split(filepath.Base(testBase), ".")
+	for _, part := range parts[1:] {
+		if part != "" {
+			tags[part] = true
+		}
+	}
+	content, err := os.ReadFile(testBase + ".tags")
+	if os.IsNotExist(err) {
+		return tags, nil
+	} else if err != nil {
+		return tags, err
+	}
+	for _, tag := range strings.FieldsFunc(string(content), func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r' }) {
+		tags[tag] = true
+	}
+	return tags, nil
+}
+
+// tagExpr is a compiled -tag expression, reporting whether a set of tags matches it
+type tagExpr func(tags map[string]bool) bool
+
+// parseTagExpr compiles a tag expression such as "large && !flaky". Tags are
+// combined with && (and), || (or), ! (not) and parentheses; && binds tighter than ||.
+func parseTagExpr(expr string) (tagExpr, error) {
+	p := &tagParser{tokens: tokenizeTagExpr(expr)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty tag expression")
+	}
+	match, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return match, nil
+}
+
+// tokenizeTagExpr splits a tag expression into operators, parentheses and tag names
+func tokenizeTagExpr(expr string) []string {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		switch {
+		case expr[i] == ' ' || expr[i] == '\t':
+			i++
+		case strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case strings.IndexByte("!()", expr[i]) >= 0:
+			tokens = append(tokens, expr[i:i+1])
+			i++
+		default:
+			start := i
+			for i < len(expr) && strings.IndexByte(" \t!()&|", expr[i]) < 0 {
+				i++
+			}
+			if i == start {
+				// A lone & or |, left for the parser to reject
+				i++
+			}
+			tokens = append(tokens, expr[start:i])
+		}
+	}
+	return tokens
+}
+
+// tagParser is a recursive descent parser over the tokens of a tag expression
+type tagParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *tagParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *tagParser) or() (tagExpr, error) {
+	left, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.pos++
+		var right tagExpr
+		if right, err = p.and(); err == nil {
+			l := left
+			left = func(tags map[string]bool) bool { return l(tags) || right(tags) }
+		}
+	}
+	return left, err
+}
+
+func (p *tagParser) and() (tagExpr, error) {
+	left, err := p.unary()
+	for err == nil && p.peek() == "&&" {
+		p.pos++
+		var right tagExpr
+		if right, err = p.unary(); err == nil {
+			l := left
+			left = func(tags map[string]bool) bool { return l(tags) && right(tags) }
+		}
+	}
+	return left, err
+}
+
+func (p *tagParser) unary() (tagExpr, error) {
+	token := p.peek()
+	p.pos++
+	switch token {
+	case "!":
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(tags map[string]bool) bool { return !operand(tags) }, nil
+	case "(":
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return inner, nil
+	case "":
+		return nil, fmt.Errorf("expression ends early")
+	case "&&", "||", ")", "&", "|":
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+	return func(tags map[string]bool) bool { return tags[token] }, nil
+}
+
+// subtaskHeader starts a section of a -subtask-sections expected file, followed by the subtask's name
+const subtaskHeader = "@@ subtask "
+
+// subtaskFile names the expected file shared by the subtasks of a test under -subtask-sections:
+// that of its name without the tags, so "12.large.in" shares "12.out" with "12.small.in"
+func subtaskFile(testBase, expectedExt string) string {
+	name, _, _ := strings.Cut(filepath.Base(testBase), ".")
+	return filepath.Join(filepath.Dir(testBase), name) + expectedExt
+}
+
+// readSubtaskSection reads the section for a test's subtask from an expected file
+// holding the answers of several subtasks, one section per subtask:
+//
+//	@@ subtask small
+//	3
+//	@@ subtask large
+//	300
+//
+// The section picked is the one named after one of the test's tags. It is an error
+// for none of them to have a section, or for several to.
+func readSubtaskSection(filename string, tags map[string]bool) (string, error) {
+	content, err := readFile(filename)
+	if err != nil {
+		return "", err
+	}
+	sections := make(map[string]string)
+	var order []string
+	current := ""
+	for i, line := range strings.SplitAfter(content, "\n") {
+		if header := strings.TrimRight(line, "\r\n"); strings.HasPrefix(header, subtaskHeader) {
+			current = strings.TrimSpace(strings.TrimPrefix(header, subtaskHeader))
+			if current == "" {
+				return "", fmt.Errorf("%s:%d: subtask section without a name", filename, i+1)
+			}
+			if _, seen := sections[current]; seen {
+				return "", fmt.Errorf("%s:%d: duplicate section for subtask %s", filename, i+1, current)
+			}
+			sections[current] = ""
+			order = append(order, current)
+		} else if current != "" {
+			sections[current] += line
+		} else if strings.TrimSpace(line) != "" {
+			return "", fmt.Errorf("%s:%d: output before the first %q line", filename, i+1, strings.TrimSpace(subtaskHeader))
+		}
+	}
+	var matching []string
+	for _, name := range order {
+		if tags[name] {
+			matching = append(matching, name)
+		}
+	}
+	switch len(matching) {
+	case 0:
+		return "", fmt.Errorf("%s has no section for the test's subtask (sections: %s)", filename, strings.Join(order, ", "))
+	case 1:
+		return sections[matching[0]], nil
+	}
+	return "", fmt.Errorf("%s has sections for several of the test's tags: %s", filename, strings.Join(matching, ", "))
+}
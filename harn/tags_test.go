@@ -0,0 +1,84 @@
+package harn
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTagExpr(t *testing.T) {
+	tags := map[string]bool{"large": true, "slow": true}
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{"large", true},
+		{"small", false},
+		{"large && !flaky", true},
+		{"large && !slow", false},
+		{"small || slow", true},
+		{"!(small || large)", false},
+		{"small && large || slow", true},
+		{"small && (large || slow)", false},
+	}
+	for _, tt := range tests {
+		match, err := parseTagExpr(tt.expr)
+		if err != nil {
+			t.Errorf("parseTagExpr(%q) failed: %v", tt.expr, err)
+		} else if match(tags) != tt.match {
+			t.Errorf("parseTagExpr(%q) matches %v, want %v", tt.expr, match(tags), tt.match)
+		}
+	}
+	for _, expr := range []string{"", "large &&", "(large", "large slow", "large & slow", "|| large"} {
+		if _, err := parseTagExpr(expr); err == nil {
+			t.Errorf("parseTagExpr(%q) accepted an invalid expression", expr)
+		}
+	}
+}
+
+func TestReadTags(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "3.large.tags"), []byte("slow, graph\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tags, err := readTags(filepath.Join(dir, "3.large"))
+	if err != nil {
+		t.Fatalf("readTags() failed: %v", err)
+	}
+	if len(tags) != 3 || !tags["large"] || !tags["slow"] || !tags["graph"] {
+		t.Errorf("readTags() = %v, want large, slow and graph", tags)
+	}
+}
+
+func TestReadSubtaskSection(t *testing.T) {
+	dir := t.TempDir()
+	shared := filepath.Join(dir, "12.out")
+	if err := os.WriteFile(shared, []byte("\n@@ subtask small\n3\n@@ subtask large\n300\n301\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := subtaskFile(filepath.Join(dir, "12.large.slow"), ".out"); got != shared {
+		t.Errorf("subtaskFile() = %q, want %q", got, shared)
+	}
+	tests := []struct {
+		tags    map[string]bool
+		want    string
+		wantErr bool
+	}{
+		{map[string]bool{"small": true}, "3\n", false},
+		{map[string]bool{"large": true, "slow": true}, "300\n301", false},
+		{map[string]bool{"medium": true}, "", true},
+		{map[string]bool{"small": true, "large": true}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := readSubtaskSection(shared, tt.tags)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("readSubtaskSection(%v) = %q, %v; want %q (error: %v)", tt.tags, got, err, tt.want, tt.wantErr)
+		}
+	}
+	if err := os.WriteFile(shared, []byte("3\n@@ subtask small\n3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSubtaskSection(shared, map[string]bool{"small": true}); err == nil {
+		t.Error("readSubtaskSection() accepted output before the first section")
+	}
+}
@@ -0,0 +1,67 @@
+package harn
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempStore hands out the temporary files needed while running tests. All files
+// live in a dedicated directory for the run and are named after the test and
+// their purpose, so they are easy to find when kept for debugging.
+type tempStore struct {
+	dir  string
+	keep bool
+}
+
+func newTempStore(keep bool) (*tempStore, error) {
+	dir, err := os.MkdirTemp("", "harn-")
+	if err != nil {
+		return nil, err
+	}
+	Log.Debugf("Using temporary directory %s", dir)
+	return &tempStore{dir: dir, keep: keep}, nil
+}
+
+// create makes a new empty temporary file such as "3-perf-123456.txt" for test "tests/3.in"
+func (t *tempStore) create(testName, purpose string) (*os.File, error) {
+	base := strings.TrimSuffix(filepath.Base(testName), filepath.Ext(testName))
+	file, err := os.CreateTemp(t.dir, base+"-"+purpose+"-*.txt")
+	if err != nil {
+		return nil, err
+	}
+	Log.Debugf("%s: created temporary %s file %s", testName, purpose, file.Name())
+	return file, nil
+}
+
+// mkdir makes a new empty temporary directory such as "3-cwd-123456" for test "tests/3.in"
+func (t *tempStore) mkdir(testName, purpose string) (string, error) {
+	base := strings.TrimSuffix(filepath.Base(testName), filepath.Ext(testName))
+	dir, err := os.MkdirTemp(t.dir, base+"-"+purpose+"-*")
+	if err != nil {
+		return "", err
+	}
+	Log.Debugf("%s: created temporary %s directory %s", testName, purpose, dir)
+	return dir, nil
+}
+
+// remove deletes a temporary file or directory once it is no longer needed, unless files are kept
+func (t *tempStore) remove(path string) {
+	if t.keep {
+		return
+	}
+	if err := os.RemoveAll(path); err != nil {
+		Log.Warnf("Failed to remove temporary file %s: %v", path, err)
+	}
+}
+
+// cleanup deletes the temporary directory and everything left in it, unless files are kept
+func (t *tempStore) cleanup() {
+	if t.keep {
+		Log.Infof("Kept temporary files in %s", t.dir)
+		return
+	}
+	if err := os.RemoveAll(t.dir); err != nil {
+		Log.Warnf("Failed to remove temporary directory %s: %v", t.dir, err)
+	}
+}
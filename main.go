@@ -1,264 +1,569 @@
 package main
 
 import (
-	"bufio"
-	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"flag"
 	"fmt"
-	"github.com/sergi/go-diff/diffmatchpatch"
+	"github.com/encodeous/harn/harn"
 	"io"
-	"log"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"time"
 )
 
-var Reset = "\033[0m"
-var Red = "\033[31m"
-var Green = "\033[32m"
-var Yellow = "\033[33m"
-var Blue = "\033[34m"
-var Magenta = "\033[35m"
-var Cyan = "\033[36m"
-var Gray = "\033[37m"
-var White = "\033[97m"
+// colorsSupported reports whether colored output should be used on stdout
+func colorsSupported() bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	return stdoutIsTerminal()
+}
+
+// stdinIsTerminal reports whether stdin is a terminal, so that harn can prompt on it
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// stdoutIsTerminal reports whether stdout is a terminal rather than a file or pipe
+func stdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
 
 func main() {
 	// Define command line flags
 	verbose := flag.Bool("v", false, "Enable full verbose output when tests fail")
 	silent := flag.Bool("s", false, "Enable silent output when tests fail")
 	timeout := flag.Duration("t", 30*time.Second, "Timeout for program execution (e.g., 5s, 1m, 500ms)")
+	problemFile := flag.String("problem", "", "problem.yaml whose time_limit and memory_limit are the defaults of -t and -m")
+	memLimit := flag.String("m", "", "Address space limit of the program, e.g. 256M or 1G (Unix only, default: none)")
+	memBudget := flag.String("mem-budget", "", "Total memory for parallel tests, e.g. 8G; -j is capped to this budget divided by -m")
+	delay := flag.Duration("delay", 0, "Wait this long between tests, e.g. for programs using a rate-limited service (not with -j)")
+	idleTimeout := flag.Duration("idle-timeout", 0, "Kill the program when it writes nothing to stdout for this long, reported as TLE (idle) (default: none)")
+	cpuLimit := flag.Duration("cpulimit", 0, "CPU time limit of the program, rounded up to whole seconds (Unix only, default: none)")
+	softLimit := flag.Duration("softlimit", 0, "Flag correct tests exceeding this duration as SLOW without killing them (0 to disable)")
 	generate := flag.Bool("g", false, "Generate output files if they don't exist")
+	perPlatform := flag.Bool("per-platform", false, "Under -g, write expected files suffixed with the current OS, e.g. test1.out.linux")
+	genWith := flag.String("gen-with", "", "Reference program that generates the expected files under -g, instead of the tested program")
 	forceGen := flag.Bool("f", false, "Overwrite the output file even if it exists")
 	useHash := flag.Bool("h", false, "Use SHA256 hash comparison with .hash files instead of .out files")
+	selfBaseline := flag.String("self-baseline", "", "Record the program's outputs in this directory on the first run, and compare later runs against them")
+	hashManifest := flag.String("hash-manifest", "", "Compare SHA256 hashes against this single sha256sum-style file instead of .hash files (implies -h)")
+	interpreter := flag.String("interpreter", "", "Run the program through this interpreter, e.g. \"python3\" (arguments are allowed)")
+	pin := flag.Bool("pin", false, "Pin each program to a single CPU for steadier timings (Linux only)")
+	strictInput := flag.Bool("strict-input", false, "On timeouts, tell a program waiting for more input after the end of its input from one stuck computing (Linux only)")
+	overhead := flag.String("overhead", "", "No-op command timed before the run to estimate startup overhead, e.g. \"true\"; times are also shown without it")
+	subtasks := flag.Bool("subtask-sections", false, "Let tests without an expected file of their own read their subtask's section of a shared one, e.g. 12.large.in from 12.out")
+	cases := flag.String("cases", "", "Also run the small tests defined inline in this manifest (the glob pattern is then optional)")
+	outdirMode := flag.Bool("outdir-mode", false, "Compare the files the program writes in its working directory with a golden directory such as test1.outdir")
+	outfileMode := flag.String("outfile-mode", "", "File the program writes its answer to, e.g. output.txt ({name} is the test's name), read instead of stdout")
+	workDir := flag.String("cwd", "", "Working directory for the program (default: the current directory)")
+	okCodes := flag.String("ok-codes", "0", "Comma-separated exit codes that count as a successful run, e.g. \"0,42\"")
+	slowStdin := flag.Bool("slow-stdin", false, "Feed stdin in small randomly sized and delayed chunks to expose buffering assumptions")
+	perf := flag.Bool("perf", false, "(Linux only) Run the program under perf stat and show its counters in verbose output")
+	pre := flag.String("pre", "", "Shell command that each input is piped through before it is sent to the program")
+	post := flag.String("post", "", "Shell command that the program's output is piped through before it is compared")
+	stdinAppend := flag.String("stdin-append", "", "String appended to every input before piping it to the program (supports escapes like \\n)")
+	trimMode := flag.String("trim", harn.TrimFull, "How outputs are trimmed before comparison: full, blank-lines, collapse-spaces or none")
+	oneline := flag.Bool("oneline", false, "Print only a single summary line, for status bars and hooks")
+	diffGranularity := flag.String("diff-granularity", harn.DiffChar, "Unit of the diffs shown for failing tests: char, word or line")
+	showWhitespace := flag.Bool("show-whitespace", false, "Show spaces, tabs and line breaks in diffs as ·, → and ¶")
+	maxDiffLines := flag.Int("max-diff-lines", 100, "Truncate each diff of a failing test to this many lines (0 for no limit)")
+	github := flag.Bool("github", false, "Print GitHub Actions ::error annotations for failing tests")
+	checkDeterminism := flag.Bool("check-determinism", false, "Run each test twice and fail it as NONDETERMINISTIC when the two outputs differ")
+	noStderr := flag.Bool("no-stderr", false, "Fail tests whose program writes anything to stderr, showing what it wrote")
+	stderrBuffer := flag.String("stderr-buffer", "", "How much of the program's stderr -no-stderr keeps to show, e.g. 64K (default: 1M); the rest is drained and dropped")
+	reproHint := flag.Bool("repro-hint", false, "Print a shell command reproducing each failing test, e.g. \"./sol < tests/12.in\"")
+	quickfix := flag.Bool("quickfix", false, "Print failing tests as \"file:line: message\" lines for editor quickfix lists")
+	jsonFile := flag.String("json", "", "Write per-test results as JSON to this file")
+	scoreByTime := flag.Bool("score-by-time", false, "Score each AC by how much faster it ran than in the -baseline, and total the scores")
+	baselineFile := flag.String("baseline", "", "Compare verdicts and timings against a previous -json results file")
+	binary := flag.Bool("binary", false, "Compare outputs byte for byte as binary data, without trimming")
+	warnWhitespace := flag.Bool("warn-whitespace", false, "Pass tests whose output differs only in whitespace, marking them as AC (whitespace)")
+	stateFile := flag.String("state", "", "File where the names of failing tests are remembered between runs (default: .harn-failures next to the tests)")
+	rerunFailed := flag.Bool("rerun-failed", false, "Run only the tests that failed in the previous run, as remembered in the -state file")
+	newFailuresOnly := flag.Bool("new-failures-only", false, "Highlight failures that weren't failing in the previous run and dim the others")
+	quarantineFile := flag.String("quarantine", "", "File listing known-flaky tests whose failures are reported as FLAKY and don't fail the run")
+	numericEqual := flag.Bool("numeric-equal", false, "Compare outputs token by token, treating equal numbers as equal regardless of spelling (1e3 = 1000)")
+	leadingZeros := flag.Bool("ignore-leading-zeros", false, "With -int-float-equal, ignore leading zeros and a leading + in numbers (007 = +7 = 7); other numeric comparisons already do")
+	intFloatEqual := flag.Bool("int-float-equal", false, "Compare outputs token by token, literally except that whole numbers match in decimal spelling (5 = 5.0)")
+	delim := flag.String("delim", "", "Characters separating tokens in -numeric-equal and -eps comparisons (default: whitespace)")
+	eps := flag.Float64("eps", 0, "Compare outputs token by token, accepting numbers within this absolute or relative error")
+	ignoreComments := flag.Bool("ignore-comments", false, "Drop comment lines (see -comment-prefix) from both outputs before comparing them")
+	commentPrefix := flag.String("comment-prefix", "#", "Prefix of the lines dropped by -ignore-comments")
+	stripAnsi := flag.Bool("strip-ansi", false, "Remove ANSI escape sequences such as colors from the output and the expected outputs before comparing")
+	columns := flag.String("columns", "", "Compare only these comma-separated columns (1-based) of each line, split like -delim tokens, e.g. \"1,3\"")
+	multiset := flag.Bool("multiset", false, "Accept outputs with the same tokens as the expected output, each as many times, in any order")
+	contains := flag.Bool("contains", false, "Accept outputs that contain the expected output anywhere (both are trimmed first)")
+	assertSorted := flag.String("assert-sorted", "", "Fail outputs whose lines or tokens are not in nondecreasing order, even if they match")
+	extraLines := flag.Bool("allow-extra-lines", false, "Pass outputs that match the expected output once their extra trailing lines are ignored")
+	maxEdits := flag.Int("max-edits", -1, "Accept outputs within this many character insertions, deletions or substitutions of the expected output")
+	round := flag.Int("round", -1, "Compare token by token, rounding numbers on both sides to this many decimals first")
+	rows := flag.Bool("rows", false, "Compare outputs line by line, and the tokens of each line like -numeric-equal (with -eps tolerance)")
+	maxFailures := flag.Int("max-failures", 0, "Stop the run after this many failing tests (0 runs everything)")
+	resumeFile := flag.String("resume", "", "Checkpoint file recording completed tests, an interrupted run resumes where it stopped")
+	outTemplate := flag.String("out-template", "", "Path template of expected files, e.g. \"golden/{name}.{ext}\" ({dir}, {name}, {ext} and $VARS are substituted)")
+	expect := flag.String("expect", "", "Expected output of the test read from stdin by a \"-\" pattern (escapes such as \\n are interpreted)")
+	expectFile := flag.String("expect-file", "", "File holding the expected output of the test read from stdin by a \"-\" pattern, e.g. <(echo 3)")
+	expectedCmd := flag.String("expected-cmd", "", "Shell command whose output, given each input on stdin, is the expected output ({input} is the input's path)")
+	requireExpected := flag.Bool("require-expected", false, "Abort before running any test if an expected output file is missing")
+	stats := flag.Bool("stats", false, "Print the total, average and largest sizes of the inputs and of the program's outputs")
+	tagged := flag.Bool("tagged", false, "Start each result line with its verdict as a plain, fixed-width token for scripts")
+	tag := flag.String("tag", "", "Run only the tests whose tags match an expression such as \"large && !flaky\"")
+	inlineDesc := flag.Bool("inline-desc", false, "Read the description of tests without a .desc file from a first input line starting with #:, removed from the input")
+	includeHidden := flag.Bool("include-hidden", false, "Let wildcards in the pattern match hidden (dot-prefixed) files and directories")
+	matchExpected := flag.Bool("match-expected", false, "The glob matches expected output files, and each input is the file sharing its base name")
+	exprMode := flag.Bool("expr", false, "Expected files hold arithmetic expressions over the input tokens ($1, $2, ...) instead of literal output")
+	passThreshold := flag.Float64("pass-threshold", 0, "Succeed if at least this fraction of tests pass, e.g. 0.9 (default: all must pass)")
+	update := flag.Bool("u", false, "Overwrite the expected file with the actual output of failing tests (asks for confirmation)")
+	assumeYes := flag.Bool("y", false, "(when -u is passed in) Accept all updates without asking")
+	showConfig := flag.Bool("show-config", false, "Print the configuration the options resolve to, then exit without running any test")
+	jobs := flag.Int("j", 1, "Number of tests to run in parallel (0 runs one per CPU)")
+	sample := flag.Int("sample", 0, "Run only a random sample of this many matched tests (0 runs all)")
+	repl := flag.Bool("repl", false, "Run tests one at a time, prompting after each failure to re-run it, show its input and outputs, or go on")
+	seedArg := flag.String("seed-arg", "", "Pass a seed to the program as its last argument: the test's index or the first token of its input (index or input)")
+	matrix := flag.String("matrix", "", "Run the whole suite once per argument set, separated by ;, e.g. '--algo=a; --algo=b'")
+	seed := flag.Int64("seed", 0, "Seed for -sample and -slow-stdin (default: random)")
+	keepTemp := flag.Bool("keep-temp", false, "Keep temporary files created during the run for inspection")
+	timeColors := flag.String("time-colors", "0.5,0.9", "Fractions of the timeout at which execution times turn yellow and red")
+	liveSummary := flag.Bool("live-summary", false, "Keep a running \"X/Y passed so far\" line under the results (only on a terminal)")
+	timeHistogram := flag.Bool("time-histogram", false, "Print a histogram of test execution times after the run")
+	logLevelName := flag.String("log-level", "warn", "Minimum level of diagnostic log messages: debug, info, warn or error")
+	logFile := flag.String("logfile", "", "Append diagnostic log messages to this file instead of stderr")
 	flag.Parse()
 
+	level, err := harn.ParseLogLevel(*logLevelName)
+	if err != nil {
+		harn.Log.Fatalf("Invalid -log-level: %v", err)
+	}
+	if err := harn.Log.Configure(level, *logFile); err != nil {
+		harn.Log.Fatalf("Failed to open log file %s: %v", *logFile, err)
+	}
+	defer harn.Log.Close()
+
 	args := flag.Args()
-	if len(args) < 2 {
-		fmt.Println("Usage: harn [options] <program_to_execute> <glob_pattern>")
+	// Whatever follows -- is passed on to the program
+	var programArgs []string
+	for i, arg := range args {
+		if arg == "--" {
+			args, programArgs = args[:i], args[i+1:]
+			break
+		}
+	}
+	if len(args) < 2 && !(len(args) == 1 && *cases != "") {
+		fmt.Println("Usage: harn [options] <program_to_execute> <glob_pattern> [-- program arguments]")
 		fmt.Println("Example: harn -v -t 5s ./myprogram 'testcases/*.in'")
 		fmt.Println("  -v               Enable full output when tests fail")
 		fmt.Println("  -t               Set timeout for program execution (default: 30s)")
+		fmt.Println("  -problem         Take the -t and -m defaults from the limits of a problem.yaml")
+		fmt.Println("  -cpulimit        (Unix only) Kill the program after this much CPU time, reported as TLE (cpu)")
+		fmt.Println("  -delay           Wait this long between tests, e.g. -delay 500ms (tests run one at a time, not with -j)")
+		fmt.Println("  -idle-timeout    Kill the program when its output stalls for this long, reported as TLE (idle)")
+		fmt.Println("  -m               (Unix only) Limit the memory (address space) of the program, e.g. 256M")
+		fmt.Println("  -softlimit       Flag correct tests slower than this duration as SLOW (e.g., 1s)")
 		fmt.Println("  -g               Generate output files if they don't exist")
 		fmt.Println("  -f               (when -g is passed in) Overwrite the output file even if it exists")
+		fmt.Println("  -gen-with        (when -g is passed in) Generate expected files with this reference program instead")
+		fmt.Println("  -per-platform    (when -g is passed in) Write expected files for this OS only, e.g. test1.out.linux")
 		fmt.Println("  -h               Use SHA256 to compare with .hash files instead of .out files")
+		fmt.Println("  -self-baseline   Compare against the program's own outputs recorded in this directory on the first run")
+		fmt.Println("  -hash-manifest   Keep the hashes of all tests in one sha256sum-style file (implies -h)")
+		fmt.Println("  -interpreter     Run the program with an interpreter, e.g. -interpreter python3 solution.py")
+		fmt.Println("  -overhead        Time a no-op command like 'true' first, and show test times adjusted by its startup cost")
+		fmt.Println("  -pin             (Linux only) Pin each program to a single CPU core for steadier timings")
+		fmt.Println("  -strict-input    (Linux only) Tell timeouts waiting for more input from compute hangs")
+		fmt.Println("  -cwd             Run the program in this working directory")
+		fmt.Println("  -outfile-mode    Compare the file the program writes, e.g. output.txt, instead of its stdout")
+		fmt.Println("  -subtask-sections")
+		fmt.Println("                   Read the answers of tests like 12.large.in from the \"@@ subtask large\" section of 12.out")
+		fmt.Println("  -cases           Also run tests defined inline in a manifest file; the glob pattern is then optional")
+		fmt.Println("  -outdir-mode     Compare all files the program writes with a golden directory, e.g. tests/1.outdir/")
+		fmt.Println("  -ok-codes        Exit codes treated as success, others are RE (default: 0)")
+		fmt.Println("  -no-stderr       Fail tests whose program writes to stderr, as STDERR, e.g. stray debug prints")
+		fmt.Println("  -stderr-buffer   How much of the stderr -no-stderr shows, e.g. 64K (default: 1M)")
+		fmt.Println("  -check-determinism")
+		fmt.Println("                   Run each test twice, failing it as NONDETERMINISTIC if the outputs differ")
+		fmt.Println("  -slow-stdin      Feed stdin in small random delayed chunks (slows down large inputs)")
+		fmt.Println("  -perf            (Linux only) Run under perf stat, counters are shown with -v")
+		fmt.Println("  -trim            Trimming before comparison: full (default), blank-lines, collapse-spaces or none")
+		fmt.Println("  -numeric-equal   Compare token by token, numbers are compared by value (1e3 = 1000.0 = +1000)")
+		fmt.Println("  -int-float-equal Compare token by token, whole numbers match their decimal spelling (5 = 5.0)")
+		fmt.Println("  -ignore-leading-zeros")
+		fmt.Println("                   With -int-float-equal, numbers match with leading zeros or + (007 = +7 = 7)")
+		fmt.Println("  -eps             Compare token by token, numbers may differ by this absolute or relative error")
+		fmt.Println("  -round           Compare token by token, numbers are rounded to N decimals on both sides first")
+		fmt.Println("  -delim           Token separator characters for token comparisons, e.g. ',' or ';\\t'")
+		fmt.Println("  -rows            Token comparison that also requires the same lines, e.g. for matrices")
+		fmt.Println("  -contains        Pass if the expected output appears anywhere in the output")
+		fmt.Println("  -multiset        Compare tokens in any order, each must appear as many times as expected")
+		fmt.Println("  -assert-sorted   Also require the output's lines or tokens to be in nondecreasing order")
+		fmt.Println("  -max-edits       Pass if the output is within N character edits (Levenshtein distance) of the expected output")
+		fmt.Println("  -allow-extra-lines")
+		fmt.Println("                   Pass if the output matches once extra trailing lines are ignored")
+		fmt.Println("  -columns         Compare only these columns of each line, e.g. '1,3' (split by -delim or whitespace)")
+		fmt.Println("  -ignore-comments Ignore lines starting with -comment-prefix (default: #) in both outputs")
+		fmt.Println("  -comment-prefix  Prefix of comment lines for -ignore-comments, e.g. '//'")
+		fmt.Println("  -strip-ansi      Remove ANSI escape codes (colors etc.) from outputs before comparing")
+		fmt.Println("  -binary          Compare raw bytes and show a hex dump of the first difference")
+		fmt.Println("  -warn-whitespace Accept whitespace-only differences with a warning instead of WA")
+		fmt.Println("  -oneline         Print only a one-line summary, e.g. \"harn: 47/50 AC, 2 WA, 1 TLE, 3.2s\"")
+		fmt.Println("  -diff-granularity")
+		fmt.Println("                   Diff failing outputs by char (default), word or line")
+		fmt.Println("  -show-whitespace Show spaces, tabs and line breaks in diffs as ·, → and ¶")
+		fmt.Println("  -max-diff-lines  Truncate diffs to N lines (default: 100, 0 for no limit)")
+		fmt.Println("  -github          Print GitHub Actions annotations for failing tests")
+		fmt.Println("  -quickfix        Print failures as file:line: message for Vim/Emacs, at the first differing line")
+		fmt.Println("  -repro-hint      Print a ready-to-paste shell command that reproduces each failing test")
+		fmt.Println("  -repl            Prompt after each failure to re-run the test, show its input and outputs, or go on")
+		fmt.Println("  -json            Write per-test results as JSON to a file")
+		fmt.Println("  -tagged          Start each result line with a plain, fixed-width verdict token like \"WA    \" for scripts")
+		fmt.Println("  -stats           Print total, average and largest input and output sizes")
+		fmt.Println("  -baseline        Report verdict changes and slowdowns against a previous -json file")
+		fmt.Println("  -score-by-time   Score each AC by its baseline time over its time, for a performance scoreboard")
+		fmt.Println("  -state           File remembering failing tests between runs (default: .harn-failures next to the tests)")
+		fmt.Println("  -rerun-failed    Run only the tests that failed in the previous run, from the -state file")
+		fmt.Println("  -new-failures-only")
+		fmt.Println("                   Highlight new failures and dim ones that were already failing")
+		fmt.Println("  -quarantine      File of known-flaky tests, their failures don't fail the run")
+		fmt.Println("  -max-failures    Stop after N failing tests")
+		fmt.Println("  -resume          Record progress in a checkpoint file and skip tests completed by an interrupted run")
+		fmt.Println("  -out-template    Where expected files are, e.g. 'golden/{name}.{ext}' or '$GOLDEN/{dir}/{name}.{ext}'")
+		fmt.Println("  -require-expected")
+		fmt.Println("                   Abort without running anything if some expected files are missing, listing them")
+		fmt.Println("  -expected-cmd    Use the output of a reference command on each input as the expected output")
+		fmt.Println("  -expect          Expected output of the single test read from stdin when the pattern is \"-\"")
+		fmt.Println("  -expect-file     File holding the expected output of the test read from stdin when the pattern is \"-\"")
+		fmt.Println("  -match-expected  The glob matches .out files, inputs are found by base name, e.g. 'tests/*.out'")
+		fmt.Println("  -tag             Run only tests whose tags match an expression, e.g. \"large && !flaky\"")
+		fmt.Println("  -inline-desc     Read descriptions from a first input line starting with #: when there's no .desc file")
+		fmt.Println("  -include-hidden  Let wildcards match hidden files and directories like .tests/ (skipped by default)")
+		fmt.Println("  -expr            Expected files contain expressions over the input, e.g. '$1 + $2'")
+		fmt.Println("  -pass-threshold  Exit successfully if at least this fraction of tests pass, e.g. 0.9")
+		fmt.Println("  -u               Overwrite expected files of failing tests with the actual output")
+		fmt.Println("  -y               (when -u is passed in) Accept every update without asking")
+		fmt.Println("  -pre             Pipe every input through a shell command first, e.g. -pre 'tr , \" \"'")
+		fmt.Println("  -post            Pipe the program's output through a shell command before comparing, e.g. -post 'cut -d\" \" -f1'")
+		fmt.Println("  -stdin-append    Append a string to every input, e.g. '\\n' or '0 0\\n'")
+		fmt.Println("  -j               Run N tests in parallel, 0 for one per CPU (default: 1)")
+		fmt.Println("  -mem-budget      Cap -j so that parallel tests fit in this much memory at their -m limit")
+		fmt.Println("  -sample          Run a random sample of N matched tests")
+		fmt.Println("  -seed            Seed used by -sample and -slow-stdin, for reproducibility")
+		fmt.Println("  -matrix          Run the suite once per ;-separated argument set and compare them, e.g. '--algo=a; --algo=b'")
+		fmt.Println("  -seed-arg        Pass the test's index (index) or its input's first token (input) as the last argument")
+		fmt.Println("  -keep-temp       Keep temporary files (e.g. perf output) and print where they are")
+		fmt.Println("  -time-colors     Fractions of -t where times turn yellow and red (default: 0.5,0.9)")
+		fmt.Println("  -time-histogram  Print a histogram of execution times after the run")
+		fmt.Println("  -live-summary    Keep a running \"X/Y passed so far\" line under the results on a terminal")
+		fmt.Println("  -show-config     Print the resolved configuration (limits, comparison, expected files) and exit")
+		fmt.Println("  -log-level       Minimum level of diagnostic logs: debug, info, warn, error (default: warn)")
+		fmt.Println("  -logfile         Append diagnostic logs to a file instead of stderr")
 		os.Exit(1)
 	}
 
-	programPath := args[0]
-	globPattern := args[1]
-
-	expectedExt := ".out"
-	if *useHash {
-		expectedExt = ".hash"
+	if *oneline && (*github || *quickfix) {
+		harn.Log.Fatalf("-oneline cannot be combined with -github or -quickfix, which print lines of their own")
 	}
-
-	// Find all .in files matching the glob pattern
-	inputFiles, err := filepath.Glob(globPattern)
+	var out io.Writer = os.Stdout
+	if *oneline {
+		// Only the one-line summary printed after the run is shown
+		out = io.Discard
+	}
+	if !colorsSupported() {
+		harn.DisableColors()
+	}
+	timeThresholds, err := parseTimeThresholds(*timeColors)
 	if err != nil {
-		log.Fatalf("Error matching glob pattern: %v", err)
+		harn.Log.Fatalf("Invalid -time-colors: %v", err)
 	}
-
-	if len(inputFiles) == 0 {
-		fmt.Printf("No files found matching pattern: %s\n", globPattern)
-		return
+	inputSuffix, err := unescape(*stdinAppend)
+	if err != nil {
+		harn.Log.Fatalf("Invalid -stdin-append value %q: %v", *stdinAppend, err)
+	}
+	expected, err := unescape(*expect)
+	if err != nil {
+		harn.Log.Fatalf("Invalid -expect value %q: %v", *expect, err)
+	}
+	successCodes, err := parseExitCodes(*okCodes)
+	if err != nil {
+		harn.Log.Fatalf("Invalid -ok-codes value %q: %v", *okCodes, err)
+	}
+	delims, err := unescape(*delim)
+	if err != nil {
+		harn.Log.Fatalf("Invalid -delim value %q: %v", *delim, err)
 	}
 
-	fmt.Printf("Found %d input files matching pattern \"%s\" (timeout: %v)\n", len(inputFiles), globPattern, *timeout)
-
-	passedTests := 0
-	totalTests := len(inputFiles)
-	generatedFiles := 0
-	var totalExecutionTime time.Duration
-
-	for _, inputFile := range inputFiles {
-		fmt.Printf("%s%s%s - ", Yellow, inputFile, Reset)
-
-		// Generate corresponding .out/.hash file name
-		outputFile := strings.TrimSuffix(inputFile, ".in") + expectedExt
-
-		// Check if the expected output file exists
-		if *generate {
-			if _, err := os.Stat(outputFile); os.IsNotExist(err) || *forceGen {
-				actualOutput, executionTime, err := executeProgram(programPath, inputFile, *timeout, *useHash)
-				totalExecutionTime += executionTime
-				execTimeStr := executionTime.Round(time.Millisecond).String()
-
-				if err != nil {
-					if err == context.DeadlineExceeded {
-						fmt.Printf("%sTLE%s [%s]: Program exceeded %v timeout\n", Gray, Reset, execTimeStr, *timeout)
-					} else {
-						fmt.Printf("%sERR%s [%s]: executing program: %v\n", Red, Reset, execTimeStr, err)
-					}
-					continue
-				}
-				err = writeFile(outputFile, actualOutput)
-				if err != nil {
-					fmt.Printf("%sERR%s [%s]: failed while writing output: %v\n", Red, Reset, execTimeStr, err)
-				} else {
-					fmt.Printf("%sGEN%s [%s]: Wrote output file %s\n", Green, Reset, execTimeStr, outputFile)
-					generatedFiles++
-				}
+	if *jobs == 0 {
+		*jobs = runtime.NumCPU()
+	}
+	var selectedColumns []int
+	if *columns != "" {
+		if selectedColumns, err = parseColumns(*columns); err != nil {
+			harn.Log.Fatalf("Invalid -columns value %q: %v", *columns, err)
+		}
+	}
+	var memLimitBytes, memBudgetBytes int64
+	if *memLimit != "" {
+		if memLimitBytes, err = harn.ParseMemory(*memLimit); err != nil {
+			harn.Log.Fatalf("Invalid -m value %q: %v", *memLimit, err)
+		}
+	}
+	if *memBudget != "" {
+		if memBudgetBytes, err = harn.ParseMemory(*memBudget); err != nil {
+			harn.Log.Fatalf("Invalid -mem-budget value %q: %v", *memBudget, err)
+		}
+	}
+	var argumentSets [][]string
+	if *matrix != "" {
+		if argumentSets, err = parseMatrix(*matrix); err != nil {
+			harn.Log.Fatalf("Invalid -matrix value %q: %v", *matrix, err)
+		}
+	}
+	var stderrBufferBytes int64
+	if *stderrBuffer != "" {
+		if stderrBufferBytes, err = harn.ParseMemory(*stderrBuffer); err != nil {
+			harn.Log.Fatalf("Invalid -stderr-buffer value %q: %v", *stderrBuffer, err)
+		}
+	}
+	if *problemFile != "" {
+		problem, err := harn.ReadProblem(*problemFile)
+		if err != nil {
+			harn.Log.Fatalf("Invalid -problem file %s: %v", *problemFile, err)
+		}
+		explicit := make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+		var limits []string
+		if problem.TimeLimit > 0 {
+			limits = append(limits, fmt.Sprintf("time limit %v", problem.TimeLimit))
+			if explicit["t"] {
+				limits[len(limits)-1] += fmt.Sprintf(" (-t %v is used instead)", *timeout)
 			} else {
-				fmt.Printf("%sSKIP%s: Output file %s found, skipping\n", Gray, Reset, outputFile)
-				passedTests++
-			}
-		} else {
-			actualOutput, executionTime, err := executeProgram(programPath, inputFile, *timeout, *useHash)
-			totalExecutionTime += executionTime
-			execTimeStr := executionTime.Round(time.Millisecond).String()
-
-			if err != nil {
-				if err == context.DeadlineExceeded {
-					fmt.Printf("%sTLE%s [%s]: Program exceeded %v timeout\n", Gray, Reset, execTimeStr, *timeout)
-				} else {
-					fmt.Printf("%sERR%s [%s]: executing program: %v\n", Red, Reset, execTimeStr, err)
-				}
-				continue
-			}
-
-			// Read expected output
-			expectedOutput, err := readFile(outputFile)
-			if err != nil {
-				fmt.Printf("%sERR%s: reading expected output file: %v\n", Red, Reset, err)
-				continue
+				*timeout = problem.TimeLimit
 			}
-
-			// Compare outputs
-			if strings.TrimSpace(actualOutput) == strings.TrimSpace(expectedOutput) {
-				fmt.Printf("%sAC%s [%s]: Output matches expected result\n", Green, Reset, execTimeStr)
-				passedTests++
-				if *verbose {
-					fmt.Printf(" === Expected:\n%s\n", expectedOutput)
-					fmt.Printf(" === End Expected:\n")
-					fmt.Printf(" === Actual:\n%s\n", actualOutput)
-					fmt.Printf(" === End Actual:\n")
-				}
+		}
+		if problem.MemoryLimit > 0 {
+			limits = append(limits, "memory limit "+harn.FormatMemory(problem.MemoryLimit))
+			if explicit["m"] {
+				limits[len(limits)-1] += fmt.Sprintf(" (-m %s is used instead)", *memLimit)
+			} else if runtime.GOOS == "windows" {
+				limits[len(limits)-1] += " (not enforced on Windows)"
 			} else {
-				fmt.Printf("%sWA%s [%s]: Output doesn't match\n", Red, Reset, execTimeStr)
-				if *verbose {
-					fmt.Printf(" === Expected:\n%s\n", expectedOutput)
-					fmt.Printf(" === End Expected:\n")
-					fmt.Printf(" === Actual:\n%s\n", actualOutput)
-					fmt.Printf(" === End Actual:\n")
-				} else if !*silent {
-					dmp := diffmatchpatch.New()
-
-					diffs := dmp.DiffMain(expectedOutput, actualOutput, false)
-
-					fmt.Printf(" === Diff:\n")
-					fmt.Println(dmp.DiffPrettyText(diffs))
-					fmt.Printf(" === End Diff (💡 Use -v flag for full output)\n")
-				}
+				memLimitBytes = problem.MemoryLimit
 			}
 		}
+		if len(limits) == 0 {
+			harn.Log.Warnf("-problem file %s sets neither time_limit nor memory_limit", *problemFile)
+		} else {
+			fmt.Fprintf(out, "Limits from %s: %s\n", *problemFile, strings.Join(limits, ", "))
+		}
 	}
-
-	// Print summary
-	fmt.Printf("\n" + strings.Repeat("=", 50) + "\n")
-	if *generate {
-		fmt.Printf("Generated %d/%d new test files\n", generatedFiles, totalTests)
-		fmt.Printf("    - %d/%d tests already exist\n", passedTests, totalTests)
-	} else {
-		fmt.Printf("Test Results: %d/%d passed\n", passedTests, totalTests)
-		fmt.Printf("Total execution time: %v\n", totalExecutionTime)
-		if totalTests > 0 {
-			fmt.Printf("Average execution time: %v\n", totalExecutionTime/time.Duration(totalTests))
+	var maxEditCount *int
+	if *maxEdits >= 0 {
+		maxEditCount = maxEdits
+	}
+	var roundDecimals *int
+	if *round >= 0 {
+		roundDecimals = round
+	}
+	comments := ""
+	if *ignoreComments {
+		if *commentPrefix == "" {
+			harn.Log.Fatalf("Invalid -comment-prefix: must not be empty")
 		}
+		comments = *commentPrefix
+	}
+	// With -cases, a program alone runs just the inline tests
+	pattern := ""
+	if len(args) > 1 {
+		pattern = args[1]
+	}
+	stateExplicit := false
+	flag.Visit(func(f *flag.Flag) { stateExplicit = stateExplicit || f.Name == "state" })
+	if !stateExplicit {
+		*stateFile = harn.DefaultStateFile(pattern)
+	}
 
-		if passedTests == totalTests {
-			fmt.Printf("🎉 All tests passed!\n")
-		} else {
-			fmt.Printf("💥 %d test(s) failed\n", totalTests-passedTests)
-		}
+	if *repl && !(stdinIsTerminal() && stdoutIsTerminal()) {
+		harn.Log.Fatalf("-repl needs an interactive terminal on stdin and stdout")
 	}
-}
 
-func executeProgram(programPath, inputFile string, timeout time.Duration, hash bool) (string, time.Duration, error) {
-	// Read input file content
-	inputContent, err := readFile(inputFile)
+	cfg := harn.Config{
+		Program:          args[0],
+		Pattern:          pattern,
+		Verbose:          *verbose,
+		Silent:           *silent,
+		Timeout:          *timeout,
+		CPULimit:         *cpuLimit,
+		IdleTimeout:      *idleTimeout,
+		Delay:            *delay,
+		MemLimit:         memLimitBytes,
+		MemBudget:        memBudgetBytes,
+		SoftLimit:        *softLimit,
+		Generate:         *generate,
+		GenWith:          *genWith,
+		PerPlatform:      *perPlatform,
+		Force:            *forceGen,
+		Hash:             *useHash,
+		HashManifest:     *hashManifest,
+		SelfBaseline:     *selfBaseline,
+		Interpreter:      strings.Fields(*interpreter),
+		Overhead:         strings.Fields(*overhead),
+		Pin:              *pin,
+		StrictInput:      *strictInput,
+		Dir:              *workDir,
+		OutputFile:       *outfileMode,
+		OutputDir:        *outdirMode,
+		Subtasks:         *subtasks,
+		CasesFile:        *cases,
+		OkCodes:          successCodes,
+		SlowStdin:        *slowStdin,
+		Perf:             *perf,
+		Pre:              *pre,
+		Post:             *post,
+		StdinAppend:      inputSuffix,
+		Trim:             *trimMode,
+		NumericEqual:     *numericEqual,
+		IntFloatEqual:    *intFloatEqual,
+		LeadingZeros:     *leadingZeros,
+		Eps:              *eps,
+		Rows:             *rows,
+		Round:            roundDecimals,
+		Contains:         *contains,
+		Multiset:         *multiset,
+		AssertSorted:     *assertSorted,
+		MaxEdits:         maxEditCount,
+		ExtraLines:       *extraLines,
+		Columns:          selectedColumns,
+		CommentPrefix:    comments,
+		StripANSI:        *stripAnsi,
+		Delims:           delims,
+		Binary:           *binary,
+		WarnWhitespace:   *warnWhitespace,
+		Expr:             *exprMode,
+		MatchExpected:    *matchExpected,
+		IncludeHidden:    *includeHidden,
+		InlineDesc:       *inlineDesc,
+		Tag:              *tag,
+		OutTemplate:      *outTemplate,
+		RequireExpected:  *requireExpected,
+		ExpectedCmd:      *expectedCmd,
+		Expect:           expected,
+		ExpectFile:       *expectFile,
+		MaxDiffLines:     *maxDiffLines,
+		ShowWhitespace:   *showWhitespace,
+		DiffGranularity:  *diffGranularity,
+		GitHub:           *github,
+		Quickfix:         *quickfix,
+		ReproHint:        *reproHint,
+		NoStderr:         *noStderr,
+		StderrBuffer:     stderrBufferBytes,
+		CheckDeterminism: *checkDeterminism,
+		Repl:             *repl,
+		JSONFile:         *jsonFile,
+		BaselineFile:     *baselineFile,
+		ScoreByTime:      *scoreByTime,
+		StateFile:        *stateFile,
+		NewFailuresOnly:  *newFailuresOnly,
+		RerunFailed:      *rerunFailed,
+		Tagged:           *tagged,
+		Stats:            *stats,
+		QuarantineFile:   *quarantineFile,
+		MaxFailures:      *maxFailures,
+		ResumeFile:       *resumeFile,
+		PassThreshold:    *passThreshold,
+		Update:           *update,
+		AssumeYes:        *assumeYes,
+		ShowConfig:       *showConfig,
+		Jobs:             *jobs,
+		Sample:           *sample,
+		Seed:             *seed,
+		SeedArg:          *seedArg,
+		ProgramArgs:      programArgs,
+		Matrix:           argumentSets,
+		KeepTemp:         *keepTemp,
+		TimeColors:       timeThresholds,
+		TimeHistogram:    *timeHistogram,
+		LiveSummary:      *liveSummary && !*oneline && stdoutIsTerminal(),
+	}
+	results, err := (&harn.Runner{Out: out}).Run(cfg)
 	if err != nil {
-		return "", 0, fmt.Errorf("failed to read input file: %v", err)
+		harn.Log.Fatalf("%v", err)
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, programPath)
-	cmd.Stdin = strings.NewReader(inputContent)
-
-	start := time.Now()
 
-	var output []byte
-	if hash {
-		hasher := sha256.New()
-		var pipe io.ReadCloser
-		pipe, err = cmd.StdoutPipe()
-		if err != nil {
-			goto errHandle
-		}
-		err = cmd.Start()
-		if err != nil {
-			goto errHandle
+	if *oneline {
+		if len(results.Tests) == 0 {
+			fmt.Println("harn: no tests found")
+		} else {
+			fmt.Println(harn.OnelineSummary(results.Tests, results.TotalTime))
 		}
+	}
+	if results.Failed {
+		harn.Log.Close()
+		os.Exit(1)
+	}
+}
 
-		hashReader := io.TeeReader(pipe, hasher)
+// unescape interprets Go escape sequences such as \n and \t in a flag value
+func unescape(value string) (string, error) {
+	return strconv.Unquote(`"` + strings.ReplaceAll(value, `"`, `\"`) + `"`)
+}
 
-		if _, err = io.Copy(io.Discard, hashReader); err == nil {
-			err = cmd.Wait()
-			output = []byte(hex.EncodeToString(hasher.Sum(nil)))
+// parseExitCodes parses a comma-separated list of exit codes such as "0,42"
+func parseExitCodes(value string) ([]int, error) {
+	var codes []int
+	for _, field := range strings.Split(value, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || code < 0 || code > 255 {
+			return nil, fmt.Errorf("%q is not an exit code between 0 and 255", strings.TrimSpace(field))
 		}
-	} else {
-		output, err = cmd.Output()
+		codes = append(codes, code)
 	}
+	return codes, nil
+}
 
-errHandle:
-	executionTime := time.Since(start)
-	if err != nil {
-		// Check if it was a timeout
-		if ctx.Err() == context.DeadlineExceeded {
-			return "", executionTime, context.DeadlineExceeded
+// parseColumns parses a comma-separated list of 1-based column numbers, such as "1,3"
+func parseColumns(value string) ([]int, error) {
+	var columns []int
+	for _, field := range strings.Split(value, ",") {
+		column, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || column < 1 {
+			return nil, fmt.Errorf("%q is not a column number, columns start at 1", strings.TrimSpace(field))
 		}
-		return "", executionTime, fmt.Errorf("program execution failed: %v", err)
+		columns = append(columns, column)
 	}
-
-	return string(output), executionTime, nil
+	return columns, nil
 }
 
-// writeFile writes content to a file
-func writeFile(filename, content string) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
+// parseMatrix parses the ;-separated argument sets of -matrix, each split into arguments like -interpreter
+func parseMatrix(value string) ([][]string, error) {
+	var sets [][]string
+	for i, set := range strings.Split(value, ";") {
+		args := strings.Fields(set)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("argument set %d is empty", i+1)
+		}
+		sets = append(sets, args)
 	}
-	defer file.Close()
-
-	_, err = file.WriteString(content)
-	return err
+	return sets, nil
 }
 
-// readFile reads the entire content of a file and returns it as a string
-func readFile(filename string) (string, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return "", err
+// parseTimeThresholds parses the "yellow,red" fractions of the timeout used to color execution times
+func parseTimeThresholds(value string) ([2]float64, error) {
+	var thresholds [2]float64
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return thresholds, fmt.Errorf("expected two comma-separated fractions, got %q", value)
 	}
-	defer file.Close()
-
-	var content strings.Builder
-	scanner := bufio.NewScanner(file)
-
-	for scanner.Scan() {
-		content.WriteString(scanner.Text())
-		content.WriteString("\n")
+	for i, part := range parts {
+		fraction, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || fraction < 0 {
+			return thresholds, fmt.Errorf("invalid fraction %q", part)
+		}
+		thresholds[i] = fraction
 	}
-
-	if err := scanner.Err(); err != nil {
-		return "", err
+	if thresholds[0] > thresholds[1] {
+		return thresholds, fmt.Errorf("the yellow fraction %v is above the red fraction %v", thresholds[0], thresholds[1])
 	}
-
-	return strings.TrimSuffix(content.String(), "\n"), nil
+	return thresholds, nil
 }